@@ -1,12 +1,20 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/jobs"
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/metrics"
 	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/multiotp"
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/otpauth"
 	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/qrwork"
 	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/validator"
 	ldapwork "github.com/slayerjk/go-valdapwork"
@@ -47,6 +55,9 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 		otpAuthErr    string
 	)
 
+	// current domain data(may be changed on config reload)
+	domain := app.domain.Load()
+
 	// decode form
 	err := app.decodePostForm(r, &form)
 	if err != nil {
@@ -79,11 +90,13 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 	// OTP field validation
 	if *app.secondFactorOn {
 		form.CheckField(validator.NotBlank(form.OTP), "otp", blankFieldErr)
-		form.CheckField(validator.ValidOTP(form.OTP), "otp", validOTPErr)
+		// PrivacyIdea token, not MultiOTP one: its digits are unknown before login
+		form.CheckField(validator.ValidOTP(form.OTP, otpauth.DefaultDigits), "otp", validOTPErr)
 	}
 
 	// check errors of form
 	if !form.Valid() {
+		metrics.LoginAttempt(metrics.LoginValidationError)
 		data := app.newTemplateData(r)
 		data.Form = form
 		app.render(w, r, http.StatusUnprocessableEntity, "login.tmpl", data)
@@ -91,8 +104,11 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// making LDAP connection with TLS
-	ldapConn, err := ldapwork.StartTLSConnWoVerification(app.userDomainFQDN)
+	start := time.Now()
+	ldapConn, err := ldapwork.StartTLSConnWoVerification(domain.userDomainFQDN)
+	metrics.ObserveLDAP(domain.userDomainFQDN, "connect", start, err)
 	if err != nil {
+		metrics.LoginAttempt(metrics.LoginLDAPConnectError)
 		app.logger.Error("failed to make LDAP TLS connection", slog.Any("error", err))
 		data := app.newTemplateData(r)
 		app.render(w, r, http.StatusUnprocessableEntity, "login.tmpl", data)
@@ -101,9 +117,11 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 
 	// trying to Bind(authenticate via LDAP)
 	app.logger.Info("making LDAP BIND", "user", form.Login)
-	bindUser := form.Login + "@" + app.userDomainFQDN
+	bindUser := form.Login + "@" + domain.userDomainFQDN
+	start = time.Now()
 	err = ldapwork.LdapBind(ldapConn, bindUser, form.Password)
-	// ldapConn, err := app.ldapConnectBind(form.Login, form.Password, app.userDomainFQDN)
+	metrics.ObserveLDAP(domain.userDomainFQDN, "bind", start, err)
+	// ldapConn, err := app.ldapConnectBind(form.Login, form.Password, domain.userDomainFQDN)
 	if err != nil {
 		form.CheckField(false, "login", ldapAuthErr)
 		app.logger.Warn("failed to do LDAP bind", "user", form.Login, slog.Any("error", err))
@@ -112,6 +130,7 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 
 	// check errors of form
 	if !form.Valid() {
+		metrics.LoginAttempt(metrics.LoginLDAPBindFailure)
 		data := app.newTemplateData(r)
 		data.Form = form
 		app.render(w, r, http.StatusUnprocessableEntity, "login.tmpl", data)
@@ -121,15 +140,16 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 	// OTP auth, if enabled
 	if *app.secondFactorOn {
 		app.logger.Info("making PrivacyIdea validate check of given user's OTP", "user", form.Login)
-		_, err := mfaAuth(app.mfaTriggerUser, app.mfaTriggerUserPass, app.mfaUrl, app.userDomainFQDN, form.Login, form.OTP)
+		err := app.checkSecondFactor(domain, form.Login, form.OTP)
 		if err != nil {
 			form.CheckField(false, "otp", otpAuthErr)
-			app.logger.Warn("failed to do make OTP Auth", slog.Any("error", err))
+			app.logger.Warn("failed to do make OTP Auth", "user", form.Login, slog.Any("error", err))
 		}
 	}
 
 	// check errors of form
 	if !form.Valid() {
+		metrics.LoginAttempt(metrics.LoginOTPFailure)
 		data := app.newTemplateData(r)
 		data.Form = form
 		app.render(w, r, http.StatusUnprocessableEntity, "login.tmpl", data)
@@ -138,7 +158,9 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 
 	// save displayName for
 	filter := fmt.Sprintf("(&(objectClass=user)(samaccountname=%s))", form.Login)
-	userDisplayName, err := ldapwork.GetAttr(ldapConn, filter, form.Login, app.userDomainBaseDN, "displayName")
+	start = time.Now()
+	userDisplayName, err := ldapwork.GetAttr(ldapConn, filter, form.Login, domain.userDomainBaseDN, "displayName")
+	metrics.ObserveLDAP(domain.userDomainFQDN, "search", start, err)
 	if err != nil {
 		app.logger.Warn("failed to do get displayName attr", "user", form.Login, slog.Any("error", err))
 	}
@@ -155,6 +177,7 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 
 	// add auth id to session
 	app.sessionManager.Put(r.Context(), "authenticatedUserID", 1)
+	metrics.LoginAttempt(metrics.LoginSuccess)
 
 	// add account name & AD displayName attr to the session
 	app.sessionManager.Put(r.Context(), "accName", form.Login)
@@ -164,8 +187,19 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/qr/view", http.StatusSeeOther)
 }
 
-// QR view page for authenticated users
+// QR view page for authenticated users: token metadata, QR is hidden until "Show QR" click
 func (app *application) qrView(w http.ResponseWriter, r *http.Request) {
+	app.renderQrView(w, r, false)
+}
+
+// Show QR on view page for qrReveal time, png is served for the same time.
+// QR is embedded only in this response, reload of view page hides it.
+func (app *application) qrRevealPost(w http.ResponseWriter, r *http.Request) {
+	app.renderQrView(w, r, true)
+}
+
+// Render QR view page, QR svg is embedded if reveal is true(and user isn't enrolled)
+func (app *application) renderQrView(w http.ResponseWriter, r *http.Request, reveal bool) {
 	data := app.newTemplateData(r)
 
 	// get accName from session
@@ -177,8 +211,30 @@ func (app *application) qrView(w http.ResponseWriter, r *http.Request) {
 		data.Username = accName
 	}
 
+	// reissue job: show progress while it's active, result when it's finished
+	if jobID := app.sessionManager.GetString(r.Context(), "reissueJobID"); len(jobID) != 0 {
+		job, ok := app.reissueJobs.Get(jobID)
+		switch {
+		case !ok:
+			app.sessionManager.Remove(r.Context(), "reissueJobID")
+		case job.State.Finished():
+			app.sessionManager.Remove(r.Context(), "reissueJobID")
+			data.Flash = app.reissueResultMessage(job.State)
+		default:
+			// QR is being deleted/recreated, nothing to show yet
+			data.ReissueJob = &job
+			app.render(w, r, http.StatusOK, "view.tmpl", data)
+			return
+		}
+	}
+
+	// current domain data(may be changed on config reload)
+	domain := app.domain.Load()
+
 	// making TLS over LDAP connection
-	ldapConn, err := ldapwork.StartTLSConnWoVerification(app.qrDomainFQDN)
+	start := time.Now()
+	ldapConn, err := ldapwork.StartTLSConnWoVerification(domain.qrDomainFQDN)
+	metrics.ObserveLDAP(domain.qrDomainFQDN, "connect", start, err)
 	if err != nil {
 		app.logger.Error("failed to make QR LDAP TLS connection", slog.Any("error", err))
 		app.render(w, r, http.StatusOK, "view.tmpl", data)
@@ -187,8 +243,10 @@ func (app *application) qrView(w http.ResponseWriter, r *http.Request) {
 	defer ldapConn.Close()
 
 	// trying to Bind(authenticate via QR LDAP)
-	bindUser := app.qrDomainBindUser + "@" + app.qrDomainFQDN
-	err = ldapwork.LdapBind(ldapConn, bindUser, app.qrDomainBindUserPass)
+	bindUser := domain.qrDomainBindUser + "@" + domain.qrDomainFQDN
+	start = time.Now()
+	err = ldapwork.LdapBind(ldapConn, bindUser, domain.qrDomainBindUserPass)
+	metrics.ObserveLDAP(domain.qrDomainFQDN, "bind", start, err)
 	if err != nil {
 		app.logger.Warn("failed to do QR LDAP bind", "user", bindUser, slog.Any("error", err))
 		app.render(w, r, http.StatusOK, "view.tmpl", data)
@@ -198,7 +256,9 @@ func (app *application) qrView(w http.ResponseWriter, r *http.Request) {
 
 	// save sAMAccountName for context
 	filter := fmt.Sprintf("(&(objectClass=user)(samaccountname=*%s))", accName)
-	userSama, err := ldapwork.GetAttr(ldapConn, filter, accName, app.qrDomainBaseDN, "sAMAccountName")
+	start = time.Now()
+	userSama, err := ldapwork.GetAttr(ldapConn, filter, accName, domain.qrDomainBaseDN, "sAMAccountName")
+	metrics.ObserveLDAP(domain.qrDomainFQDN, "search", start, err)
 	if err != nil {
 		app.logger.Warn("failed to do get samaAccountName attr", "user", accName, slog.Any("error", err))
 		app.render(w, r, http.StatusOK, "view.tmpl", data)
@@ -210,7 +270,7 @@ func (app *application) qrView(w http.ResponseWriter, r *http.Request) {
 	app.sessionManager.Put(r.Context(), "QrAcc", userSama)
 
 	// get totpURL
-	totpURL, err := multiotp.GetMultiOTPTokenURL(userSama, *app.multiOTPBinPath)
+	totpURL, err := app.multiOTP.GetTokenURL(r.Context(), userSama)
 	if err != nil {
 		// app.serverError(w, r, fmt.Errorf("failed to get totpURL:\n\t%v", err))
 		app.logger.Warn("failed to find totpURL", "user", userSama, slog.Any("error", err))
@@ -219,25 +279,60 @@ func (app *application) qrView(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// get QR svg content(between <svg> tags)
-	qr, err := qrwork.GenerateTOTPSvgQrHTML(totpURL)
+	data.TokenFound = true
+	data.QRRevealSeconds = int(app.qrReveal.Seconds())
+	data.EnrollmentMode = app.enrollmentMode
+	// enrolled user's QR isn't shown, only reissue is offered
+	data.Enrolled = app.enrolled(r, userSama)
+
+	// issuer & label shown in authenticator app are rewritten,
+	// QR of MultiOTP's URL is shown if it can't be parsed
+	key, err := app.tokenKey(r, userSama, totpURL)
 	if err != nil {
-		// app.serverError(w, r, fmt.Errorf("failed to get qr for %s:\n\t%v", accName, err))
-		app.logger.Warn("failed to generate QR", "user", userSama, slog.Any("error", err))
-		// render view.tmpl with empty QR
+		app.logger.Warn("failed to parse totpURL", "user", userSama, slog.Any("error", err))
+	} else {
+		totpURL = key.String()
+	}
+
+	if reveal && !data.Enrolled {
+		// unix time: session codec(gob) needs registration for time.Time
+		app.sessionManager.Put(r.Context(), "qrRevealedUntil", time.Now().Add(app.qrReveal).Unix())
+
+		// get QR svg content(between <svg> tags)
+		qr, err := qrwork.GenerateTOTPSvgQrHTML([]byte(totpURL), app.qrOptions)
+		if err != nil {
+			// app.serverError(w, r, fmt.Errorf("failed to get qr for %s:\n\t%v", accName, err))
+			app.logger.Warn("failed to generate QR", "user", userSama, slog.Any("error", err))
+		}
+
+		// save string qr as HTML code, page with QR must not be cached
+		data.QR = template.HTML(qr)
+		w.Header().Set("Cache-Control", "no-store")
+	}
+
+	// token metadata: QR is shown even if it can't be parsed
+	if key == nil {
 		app.render(w, r, http.StatusOK, "view.tmpl", data)
 		return
 	}
+	// OTP inputs(resync, test) are validated by user's token digits
+	app.sessionManager.Put(r.Context(), "otpDigits", key.Digits)
 
-	// save string qr as HTML code
-	data.QR = template.HTML(qr)
+	// lock & sync status are unknown if user info fails
+	info, err := app.multiOTP.UserInfo(r.Context(), userSama)
+	if err != nil {
+		app.logger.Warn("failed to get MultiOTP user info", "user", userSama, slog.Any("error", err))
+	}
+	data.Token = newTokenInfo(key, info)
 
 	// app.render(w, r, http.StatusOK, "create.tmpl", data)
 	app.render(w, r, http.StatusOK, "view.tmpl", data)
 }
 
-// Reissue QR and redirect ot qrView for authenticated users
-func (app *application) qrReissue(w http.ResponseWriter, r *http.Request) {
+// Enqueue QR reissue job and redirect to qrView for authenticated users.
+// Job ID is kept in session, qrView shows its progress.
+// POST+redirect: page reload doesn't resubmit the job.
+func (app *application) qrReissuePost(w http.ResponseWriter, r *http.Request) {
 	// get accName from session
 	qrAcc := app.sessionManager.GetString(r.Context(), "QrAcc")
 	if len(qrAcc) == 0 {
@@ -247,19 +342,738 @@ func (app *application) qrReissue(w http.ResponseWriter, r *http.Request) {
 			app.sessionManager.Put(r.Context(), "flash", "Your QR hasn't been reissued!")
 		}
 		http.Redirect(w, r, "/qr/view", http.StatusSeeOther)
+		return
+	}
+
+	// token profile chosen by user, empty - MultiOTP default token
+	profile := r.PostFormValue("profile")
+	if len(profile) != 0 && app.tokenProfile(profile) == nil {
+		app.logger.Warn("failed to reissue QR, unknown token profile", "acc", qrAcc, "profile", profile)
+		app.sessionManager.Put(r.Context(), "flash", "Ваш QR НЕ перевыпущен!")
+		if *app.lang == "en" {
+			app.sessionManager.Put(r.Context(), "flash", "Your QR hasn't been reissued!")
+		}
+		http.Redirect(w, r, "/qr/view", http.StatusSeeOther)
+		return
 	}
 
-	// make reissue of user(del->resync)
-	err := multiotp.ReissueMultiOTPQR(*app.multiOTPBinPath, qrAcc)
+	// enqueue reissue of user(del->resync), user's active job is returned if exists
+	job, err := app.reissueJobs.Enqueue(qrAcc, profile)
+	if errors.Is(err, jobs.ErrOtherProfile) {
+		// active job's progress is shown, user is told the chosen profile isn't applied
+		app.logger.Warn("QR reissue with other token profile is running", "acc", qrAcc, "profile", profile, "activeProfile", job.Profile, "jobID", job.ID)
+		app.sessionManager.Put(r.Context(), "reissueJobID", job.ID)
+		app.sessionManager.Put(r.Context(), "flash", "Перевыпуск QR с другим профилем токена уже выполняется, выбранный профиль НЕ применён!")
+		if *app.lang == "en" {
+			app.sessionManager.Put(r.Context(), "flash", "QR reissue with other token profile is already running, chosen profile hasn't been applied!")
+		}
+		http.Redirect(w, r, "/qr/view", http.StatusSeeOther)
+		return
+	}
 	if err != nil {
-		app.logger.Error("failed to reissue QR", "acc", qrAcc, slog.Any("error", err))
+		app.logger.Error("failed to enqueue QR reissue", "acc", qrAcc, slog.Any("error", err))
+		app.sessionManager.Put(r.Context(), "flash", "Ваш QR НЕ перевыпущен!")
+		if *app.lang == "en" {
+			app.sessionManager.Put(r.Context(), "flash", "Your QR hasn't been reissued!")
+		}
+		http.Redirect(w, r, "/qr/view", http.StatusSeeOther)
+		return
 	}
 
-	app.sessionManager.Put(r.Context(), "flash", "Ваш QR перевыпущен!")
-	if *app.lang == "en" {
-		app.sessionManager.Put(r.Context(), "flash", "Your QR has been reissued!")
+	app.logger.Info("QR reissue job enqueued", "acc", qrAcc, "profile", job.Profile, "jobID", job.ID)
+	app.sessionManager.Put(r.Context(), "reissueJobID", job.ID)
+
+	http.Redirect(w, r, "/qr/view", http.StatusSeeOther)
+}
+
+// Reissue job status(JSON) for polling from view page.
+// Only the job saved in user's session is available.
+func (app *application) qrReissueStatus(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id != app.sessionManager.GetString(r.Context(), "reissueJobID") {
+		http.NotFound(w, r)
+		return
+	}
+
+	job, ok := app.reissueJobs.Get(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(job)
+	if err != nil {
+		app.logger.Error("failed to write reissue job status", slog.Any("error", err))
+	}
+}
+
+// Run reissue job(called by jobs queue worker), profile may be empty(default token)
+func (app *application) runReissueJob(ctx context.Context, user, profile string, progress func(jobs.State)) error {
+	// new QR is shown until its enrollment is confirmed: marker is reset
+	// as soon as old token is deleted(create or sync step starts),
+	// so it's reset even if the rest of reissue fails
+	enrollmentReset := false
+	err := app.multiOTP.Reissue(ctx, user, app.tokenProfile(profile), func(step string) {
+		progress(jobs.State(step))
+
+		if !app.enrollmentMode || enrollmentReset || (step != multiotp.StepCreating && step != multiotp.StepSyncing) {
+			return
+		}
+		enrollmentReset = true
+		if err := app.sessionStore.ResetEnrolled(ctx, user); err != nil {
+			app.logger.Error("failed to reset enrollment", "acc", user, slog.Any("error", err))
+		}
+	})
+	if err != nil {
+		app.logger.Error("failed to reissue QR", "acc", user, "profile", profile, slog.Any("error", err))
+		return err
+	}
+
+	app.logger.Info("QR reissued", "acc", user, "profile", profile)
+	return nil
+}
+
+// Token resync form: two consecutive OTPs
+type qrResyncForm struct {
+	OTP1                string `form:"otp1"`
+	OTP2                string `form:"otp2"`
+	validator.Validator `form:"-"`
+}
+
+// Display token resync page
+func (app *application) qrResync(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = qrResyncForm{}
+	app.render(w, r, http.StatusOK, "resync.tmpl", data)
+}
+
+// Resync user's token(clock drift) with two consecutive OTPs,
+// result is shown as flash message on qrView.
+// Attempts are limited per user(otpTestMaxAttempts in otpTestWindow, own counter).
+func (app *application) qrResyncPost(w http.ResponseWriter, r *http.Request) {
+	var (
+		form          qrResyncForm
+		blankFieldErr string
+		validOTPErr   string
+		distinctErr   string
+	)
+
+	// decode form
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// localization set
+	if *app.lang == "ru" {
+		blankFieldErr = "Это поле не может быть пустым"
+		validOTPErr = "OTP не валидный"
+		distinctErr = "Коды должны быть разными(два последовательных кода)"
+	} else {
+		blankFieldErr = "This field cannot be blank"
+		validOTPErr = "OTP is not valid"
+		distinctErr = "Codes must be different(two consecutive codes)"
+	}
+
+	// OTPs validation
+	form.CheckField(validator.NotBlank(form.OTP1), "otp1", blankFieldErr)
+	digits := app.otpDigits(r)
+	form.CheckField(validator.ValidOTP(form.OTP1, digits), "otp1", validOTPErr)
+	form.CheckField(validator.NotBlank(form.OTP2), "otp2", blankFieldErr)
+	form.CheckField(validator.ValidOTP(form.OTP2, digits), "otp2", validOTPErr)
+	form.CheckField(validator.Distinct(form.OTP1, form.OTP2), "otp2", distinctErr)
+
+	// check errors of form
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "resync.tmpl", data)
+		return
+	}
+
+	// QrAcc is saved by qrView
+	qrAcc := app.sessionManager.GetString(r.Context(), "QrAcc")
+	if len(qrAcc) == 0 {
+		err = errors.New("empty QrAcc")
+	} else {
+		// rate limit by MultiOTP account as "test my token": resync checks codes too
+		if ok, wait := app.resyncLimiter.Allow(qrAcc); !ok {
+			app.logger.Warn("token resync rate limited", "acc", qrAcc, "retryAfter", wait)
+			form.AddNonFieldError(app.attemptsLimitMessage(wait))
+			data := app.newTemplateData(r)
+			data.Form = qrResyncForm{Validator: form.Validator}
+			w.Header().Set("Retry-After", fmt.Sprint(int(wait.Seconds())+1))
+			app.render(w, r, http.StatusTooManyRequests, "resync.tmpl", data)
+			return
+		}
+
+		err = app.multiOTP.Resync(r.Context(), qrAcc, form.OTP1, form.OTP2)
+	}
+	if err != nil {
+		app.logger.Warn("failed to resync token", "acc", qrAcc, slog.Any("error", err))
+	} else {
+		app.logger.Info("token resynced", "acc", qrAcc)
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", app.resyncResultMessage(err))
+	http.Redirect(w, r, "/qr/view", http.StatusSeeOther)
+}
+
+// "Test my token" form: current OTP
+type qrTestForm struct {
+	OTP                 string `form:"otp"`
+	validator.Validator `form:"-"`
+}
+
+// Display "test my token" page
+func (app *application) qrTest(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = qrTestForm{}
+	app.render(w, r, http.StatusOK, "test.tmpl", data)
+}
+
+// Check user's OTP against MultiOTP account(QrAcc).
+// Attempts are limited per user(otpTestMaxAttempts in otpTestWindow),
+// so the page can't be used to brute-force codes.
+// Success is shown as flash message, failure as form error.
+func (app *application) qrTestPost(w http.ResponseWriter, r *http.Request) {
+	var (
+		form          qrTestForm
+		blankFieldErr string
+		validOTPErr   string
+	)
+
+	// decode form
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// localization set
+	if *app.lang == "ru" {
+		blankFieldErr = "Это поле не может быть пустым"
+		validOTPErr = "OTP не валидный"
+	} else {
+		blankFieldErr = "This field cannot be blank"
+		validOTPErr = "OTP is not valid"
+	}
+
+	// OTP validation
+	form.CheckField(validator.NotBlank(form.OTP), "otp", blankFieldErr)
+	form.CheckField(validator.ValidOTP(form.OTP, app.otpDigits(r)), "otp", validOTPErr)
+
+	// check errors of form
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "test.tmpl", data)
+		return
+	}
+
+	// QrAcc is saved by qrView
+	qrAcc := app.sessionManager.GetString(r.Context(), "QrAcc")
+	if len(qrAcc) == 0 {
+		app.logger.Error("failed to test OTP, Empty QrAcc")
+		form.AddNonFieldError(app.otpTestResultMessage(errors.New("empty QrAcc")))
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "test.tmpl", data)
+		return
+	}
+
+	// rate limit by MultiOTP account(not session: new login doesn't reset it)
+	if ok, wait := app.otpTestLimiter.Allow(qrAcc); !ok {
+		app.logger.Warn("OTP test rate limited", "acc", qrAcc, "retryAfter", wait)
+		form.AddNonFieldError(app.attemptsLimitMessage(wait))
+		data := app.newTemplateData(r)
+		data.Form = form
+		w.Header().Set("Retry-After", fmt.Sprint(int(wait.Seconds())+1))
+		app.render(w, r, http.StatusTooManyRequests, "test.tmpl", data)
+		return
+	}
+
+	err = app.multiOTP.CheckOTP(r.Context(), qrAcc, form.OTP)
+	if err != nil {
+		app.logger.Warn("OTP test failed", "acc", qrAcc, slog.Any("error", err))
+		form.AddNonFieldError(app.otpTestResultMessage(err))
+		data := app.newTemplateData(r)
+		data.Form = qrTestForm{Validator: form.Validator}
+		app.render(w, r, http.StatusUnprocessableEntity, "test.tmpl", data)
+		return
+	}
+
+	app.logger.Info("OTP test passed", "acc", qrAcc)
+	app.sessionManager.Put(r.Context(), "flash", app.otpTestResultMessage(nil))
+	http.Redirect(w, r, "/qr/test", http.StatusSeeOther)
+}
+
+// Self-unlock form: password re-entry
+type qrUnlockForm struct {
+	Password            string `form:"password"`
+	validator.Validator `form:"-"`
+}
+
+// Display self-unlock page
+func (app *application) qrUnlock(w http.ResponseWriter, r *http.Request) {
+	// self-unlock is disabled by selfUnlockMaxPerDay = 0
+	if app.unlockMax == 0 {
+		app.sessionManager.Put(r.Context(), "flash", app.unlockDisabledMessage())
+		http.Redirect(w, r, "/qr/view", http.StatusSeeOther)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = qrUnlockForm{}
+	app.render(w, r, http.StatusOK, "unlock.tmpl", data)
+}
+
+// Unlock user's locked MultiOTP token('-unlock') after password re-entry.
+// Unlocks are limited per user(selfUnlockMaxPerDay in 24h) and audited.
+// Result is shown as flash message on qrView.
+func (app *application) qrUnlockPost(w http.ResponseWriter, r *http.Request) {
+	if app.unlockMax == 0 {
+		app.sessionManager.Put(r.Context(), "flash", app.unlockDisabledMessage())
+		http.Redirect(w, r, "/qr/view", http.StatusSeeOther)
+		return
+	}
+
+	var (
+		form           qrUnlockForm
+		blankFieldErr  string
+		wrongPassErr   string
+		unavailableErr string
+		notLockedMsg   string
+		limitErr       string
+	)
+
+	// decode form
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// localization set
+	if *app.lang == "ru" {
+		blankFieldErr = "Это поле не может быть пустым"
+		wrongPassErr = "Не верный пароль"
+		unavailableErr = "Не удалось проверить пароль, попробуйте позже"
+		notLockedMsg = "Ваш токен не заблокирован"
+		limitErr = "Превышено количество разблокировок за сутки, обратитесь в службу поддержки"
+	} else {
+		blankFieldErr = "This field cannot be blank"
+		wrongPassErr = "Wrong password"
+		unavailableErr = "Failed to check your password, try later"
+		notLockedMsg = "Your token isn't locked"
+		limitErr = "Too many unlocks in 24h, contact your helpdesk"
+	}
+
+	// password validation
+	form.CheckField(validator.NotBlank(form.Password), "password", blankFieldErr)
+
+	// check errors of form
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "unlock.tmpl", data)
+		return
+	}
+
+	// QrAcc is saved by qrView
+	accName := app.sessionManager.GetString(r.Context(), "accName")
+	qrAcc := app.sessionManager.GetString(r.Context(), "QrAcc")
+	if len(qrAcc) == 0 {
+		app.logger.Error("failed to unlock token, Empty QrAcc")
+		app.sessionManager.Put(r.Context(), "flash", app.unlockResultMessage(errors.New("empty QrAcc")))
+		http.Redirect(w, r, "/qr/view", http.StatusSeeOther)
+		return
+	}
+
+	// unlock only locked token(unknown status is treated as locked)
+	info, err := app.multiOTP.UserInfo(r.Context(), qrAcc)
+	if err == nil {
+		if locked, ok := info.Locked(); ok && !locked {
+			app.sessionManager.Put(r.Context(), "flash", notLockedMsg)
+			http.Redirect(w, r, "/qr/view", http.StatusSeeOther)
+			return
+		}
+	}
+
+	// password re-entries are limited per login before LDAP bind,
+	// so the page can't be used to guess domain password
+	if ok, wait := app.passLimiter.Allow(accName); !ok {
+		app.audit(r, "unlock", qrAcc, fmt.Errorf("password attempts limit reached, retry after %s", wait.Round(time.Second)))
+		form.AddNonFieldError(app.attemptsLimitMessage(wait))
+		data := app.newTemplateData(r)
+		data.Form = qrUnlockForm{Validator: form.Validator}
+		w.Header().Set("Retry-After", fmt.Sprint(int(wait.Seconds())+1))
+		app.render(w, r, http.StatusTooManyRequests, "unlock.tmpl", data)
+		return
+	}
+
+	// prove identity again: password of user domain account
+	err = app.checkPassword(accName, form.Password)
+	if err != nil {
+		app.logger.Warn("failed to check password for unlock", "user", accName, slog.Any("error", err))
+		message := wrongPassErr
+		if errors.Is(err, errLDAPUnavailable) {
+			message = unavailableErr
+		}
+		form.AddNonFieldError(message)
+		app.audit(r, "unlock", qrAcc, fmt.Errorf("password check failed: %w", err))
+		data := app.newTemplateData(r)
+		data.Form = qrUnlockForm{Validator: form.Validator}
+		app.render(w, r, http.StatusUnprocessableEntity, "unlock.tmpl", data)
+		return
+	}
+
+	// cap of self-unlocks per day
+	ok, wait, err := app.sessionStore.AllowUnlock(r.Context(), qrAcc, app.unlockMax, 24*time.Hour)
+	if err != nil {
+		app.logger.Error("failed to check self-unlocks limit", "user", qrAcc, slog.Any("error", err))
+		err = fmt.Errorf("failed to check self-unlocks limit: %w", err)
+		app.audit(r, "unlock", qrAcc, err)
+		app.sessionManager.Put(r.Context(), "flash", app.unlockResultMessage(err))
+		http.Redirect(w, r, "/qr/view", http.StatusSeeOther)
+		return
+	}
+	if !ok {
+		app.audit(r, "unlock", qrAcc, fmt.Errorf("self-unlocks limit reached, retry after %s", wait.Round(time.Minute)))
+		form.AddNonFieldError(limitErr)
+		data := app.newTemplateData(r)
+		data.Form = qrUnlockForm{Validator: form.Validator}
+		app.render(w, r, http.StatusTooManyRequests, "unlock.tmpl", data)
+		return
+	}
+
+	err = app.multiOTP.UnlockUser(r.Context(), qrAcc)
+	app.audit(r, "unlock", qrAcc, err)
+
+	app.sessionManager.Put(r.Context(), "flash", app.unlockResultMessage(err))
+	http.Redirect(w, r, "/qr/view", http.StatusSeeOther)
+}
+
+// Serve user's QR as png image(size in px by 'size' query param, default qrPngSize).
+// 'download' query param makes browser save image as file.
+// Image contains the secret, so it must not be cached.
+func (app *application) qrImage(w http.ResponseWriter, r *http.Request) {
+	// QrAcc is saved by qrView
+	qrAcc := app.sessionManager.GetString(r.Context(), "QrAcc")
+	if len(qrAcc) == 0 {
+		app.logger.Warn("failed to get QR png, Empty QrAcc")
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	// served only for qrReveal time after "Show QR" click, never for enrolled user
+	if !app.qrRevealed(r) || app.enrolled(r, qrAcc) {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	size := app.qrPngSize
+	if value := r.URL.Query().Get("size"); len(value) != 0 {
+		var err error
+		size, err = strconv.Atoi(value)
+		if err != nil || size < qrwork.MinPNGSize || size > qrwork.MaxPNGSize {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+	}
+
+	totpURL, err := app.multiOTP.GetTokenURL(r.Context(), qrAcc)
+	if err != nil {
+		app.logger.Warn("failed to find totpURL", "user", qrAcc, slog.Any("error", err))
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	// the same URL as QR on qrView
+	key, err := app.tokenKey(r, qrAcc, totpURL)
+	if err != nil {
+		app.logger.Warn("failed to parse totpURL", "user", qrAcc, slog.Any("error", err))
+	} else {
+		totpURL = key.String()
+	}
+
+	img, err := qrwork.GenerateTOTPPng([]byte(totpURL), size, app.qrOptions)
+	if err != nil {
+		app.serverError(w, r, fmt.Errorf("failed to generate QR png for %s: %w", qrAcc, err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Length", strconv.Itoa(len(img)))
+	w.Header().Set("Cache-Control", "no-store")
+	if r.URL.Query().Has("download") {
+		w.Header().Set("Content-Disposition", `attachment; filename="qr.png"`)
+	}
+	w.Write(img)
+}
+
+// Display print-friendly page with QR png
+func (app *application) qrPrint(w http.ResponseWriter, r *http.Request) {
+	// QrAcc is saved by qrView
+	qrAcc := app.sessionManager.GetString(r.Context(), "QrAcc")
+	if len(qrAcc) == 0 {
+		http.Redirect(w, r, "/qr/view", http.StatusSeeOther)
+		return
+	}
+	if app.enrolled(r, qrAcc) {
+		app.sessionManager.Put(r.Context(), "flash", app.enrolledMessage())
+		http.Redirect(w, r, "/qr/view", http.StatusSeeOther)
+		return
+	}
+	// QR png is served only for qrReveal time after "Show QR" click
+	if !app.qrRevealed(r) {
+		app.sessionManager.Put(r.Context(), "flash", app.qrHiddenMessage())
+		http.Redirect(w, r, "/qr/view", http.StatusSeeOther)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Username = app.sessionManager.GetString(r.Context(), "displayName")
+	if len(data.Username) == 0 {
+		data.Username = app.sessionManager.GetString(r.Context(), "accName")
+	}
+	data.QRPrintSize = 2 * app.qrPngSize
+	if data.QRPrintSize > qrwork.MaxPNGSize {
+		data.QRPrintSize = qrwork.MaxPNGSize
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	app.render(w, r, http.StatusOK, "print.tmpl", data)
+}
+
+type qrSecretForm struct {
+	Password            string `form:"password"`
+	validator.Validator `form:"-"`
+}
+
+// Display "can't scan? show the secret key" page
+func (app *application) qrSecret(w http.ResponseWriter, r *http.Request) {
+	// the secret is shown only until enrollment, as QR
+	if app.enrolled(r, app.sessionManager.GetString(r.Context(), "QrAcc")) {
+		app.sessionManager.Put(r.Context(), "flash", app.enrolledMessage())
+		http.Redirect(w, r, "/qr/view", http.StatusSeeOther)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = qrSecretForm{}
+	app.render(w, r, http.StatusOK, "secret.tmpl", data)
+}
+
+// Reveal base32 secret of user's token(for manual entry) after password re-entry.
+// Reveals are audited, the page must not be cached.
+func (app *application) qrSecretPost(w http.ResponseWriter, r *http.Request) {
+	var (
+		form           qrSecretForm
+		blankFieldErr  string
+		wrongPassErr   string
+		unavailableErr string
+	)
+
+	// decode form
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// localization set
+	if *app.lang == "ru" {
+		blankFieldErr = "Это поле не может быть пустым"
+		wrongPassErr = "Не верный пароль"
+		unavailableErr = "Не удалось проверить пароль, попробуйте позже"
+	} else {
+		blankFieldErr = "This field cannot be blank"
+		wrongPassErr = "Wrong password"
+		unavailableErr = "Failed to check your password, try later"
+	}
+
+	// password validation
+	form.CheckField(validator.NotBlank(form.Password), "password", blankFieldErr)
+
+	// check errors of form
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "secret.tmpl", data)
+		return
+	}
+
+	// QrAcc is saved by qrView
+	accName := app.sessionManager.GetString(r.Context(), "accName")
+	qrAcc := app.sessionManager.GetString(r.Context(), "QrAcc")
+	if len(qrAcc) == 0 {
+		app.logger.Error("failed to reveal secret, Empty QrAcc")
+		http.Redirect(w, r, "/qr/view", http.StatusSeeOther)
+		return
+	}
+	if app.enrolled(r, qrAcc) {
+		app.sessionManager.Put(r.Context(), "flash", app.enrolledMessage())
+		http.Redirect(w, r, "/qr/view", http.StatusSeeOther)
+		return
+	}
+
+	// password re-entries are limited per login before LDAP bind
+	// (shared with self-unlock), rejected attempts are audited too
+	if ok, wait := app.passLimiter.Allow(accName); !ok {
+		app.audit(r, "secret", qrAcc, fmt.Errorf("password attempts limit reached, retry after %s", wait.Round(time.Second)))
+		form.AddNonFieldError(app.attemptsLimitMessage(wait))
+		data := app.newTemplateData(r)
+		data.Form = qrSecretForm{Validator: form.Validator}
+		w.Header().Set("Retry-After", fmt.Sprint(int(wait.Seconds())+1))
+		app.render(w, r, http.StatusTooManyRequests, "secret.tmpl", data)
+		return
+	}
+
+	// prove identity again: password of user domain account
+	err = app.checkPassword(accName, form.Password)
+	if err != nil {
+		app.logger.Warn("failed to check password for secret reveal", "user", accName, slog.Any("error", err))
+		message := wrongPassErr
+		if errors.Is(err, errLDAPUnavailable) {
+			message = unavailableErr
+		}
+		form.AddNonFieldError(message)
+		app.audit(r, "secret", qrAcc, fmt.Errorf("password check failed: %w", err))
+		data := app.newTemplateData(r)
+		data.Form = qrSecretForm{Validator: form.Validator}
+		app.render(w, r, http.StatusUnprocessableEntity, "secret.tmpl", data)
+		return
+	}
+
+	totpURL, err := app.multiOTP.GetTokenURL(r.Context(), qrAcc)
+	if err != nil {
+		app.logger.Warn("failed to find totpURL", "user", qrAcc, slog.Any("error", err))
+		app.audit(r, "secret", qrAcc, err)
+		app.sessionManager.Put(r.Context(), "flash", app.secretFailedMessage())
+		http.Redirect(w, r, "/qr/view", http.StatusSeeOther)
+		return
+	}
+	key, err := app.tokenKey(r, qrAcc, totpURL)
+	if err != nil {
+		app.logger.Warn("failed to parse totpURL", "user", qrAcc, slog.Any("error", err))
+		app.audit(r, "secret", qrAcc, err)
+		app.sessionManager.Put(r.Context(), "flash", app.secretFailedMessage())
+		http.Redirect(w, r, "/qr/view", http.StatusSeeOther)
+		return
+	}
+	app.audit(r, "secret", qrAcc, nil)
+
+	data := app.newTemplateData(r)
+	data.Form = qrSecretForm{}
+	data.Secret = otpauth.FormatSecret(key.Secret)
+	data.Token = newTokenInfo(key, nil)
+	w.Header().Set("Cache-Control", "no-store")
+	app.render(w, r, http.StatusOK, "secret.tmpl", data)
+}
+
+type qrEnrollForm struct {
+	OTP                 string `form:"otp"`
+	validator.Validator `form:"-"`
+}
+
+// Display enrollment confirmation page(enrollmentMode only)
+func (app *application) qrEnroll(w http.ResponseWriter, r *http.Request) {
+	if !app.enrollmentMode {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = qrEnrollForm{}
+	app.render(w, r, http.StatusOK, "enroll.tmpl", data)
+}
+
+// Confirm enrollment with valid OTP(enrollmentMode only): enrollment marker is saved,
+// QR isn't shown anymore(until reissue). Attempts share "test my token" limit.
+func (app *application) qrEnrollPost(w http.ResponseWriter, r *http.Request) {
+	if !app.enrollmentMode {
+		app.clientError(w, http.StatusNotFound)
+		return
+	}
+
+	var (
+		form          qrEnrollForm
+		blankFieldErr string
+		validOTPErr   string
+	)
+
+	// decode form
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// localization set
+	if *app.lang == "ru" {
+		blankFieldErr = "Это поле не может быть пустым"
+		validOTPErr = "OTP не валидный"
+	} else {
+		blankFieldErr = "This field cannot be blank"
+		validOTPErr = "OTP is not valid"
+	}
+
+	// OTP validation
+	form.CheckField(validator.NotBlank(form.OTP), "otp", blankFieldErr)
+	form.CheckField(validator.ValidOTP(form.OTP, app.otpDigits(r)), "otp", validOTPErr)
+
+	// check errors of form
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "enroll.tmpl", data)
+		return
+	}
+
+	// QrAcc is saved by qrView
+	qrAcc := app.sessionManager.GetString(r.Context(), "QrAcc")
+	if len(qrAcc) == 0 {
+		app.logger.Error("failed to confirm enrollment, Empty QrAcc")
+		form.AddNonFieldError(app.otpTestResultMessage(errors.New("empty QrAcc")))
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "enroll.tmpl", data)
+		return
+	}
+
+	// rate limit by MultiOTP account, the same as "test my token"
+	if ok, wait := app.otpTestLimiter.Allow(qrAcc); !ok {
+		app.logger.Warn("enrollment confirmation rate limited", "acc", qrAcc, "retryAfter", wait)
+		form.AddNonFieldError(app.attemptsLimitMessage(wait))
+		data := app.newTemplateData(r)
+		data.Form = form
+		w.Header().Set("Retry-After", fmt.Sprint(int(wait.Seconds())+1))
+		app.render(w, r, http.StatusTooManyRequests, "enroll.tmpl", data)
+		return
+	}
+
+	err = app.multiOTP.CheckOTP(r.Context(), qrAcc, form.OTP)
+	if err != nil {
+		app.logger.Warn("enrollment confirmation failed", "acc", qrAcc, slog.Any("error", err))
+		app.audit(r, "enroll", qrAcc, err)
+		form.AddNonFieldError(app.otpTestResultMessage(err))
+		data := app.newTemplateData(r)
+		data.Form = qrEnrollForm{Validator: form.Validator}
+		app.render(w, r, http.StatusUnprocessableEntity, "enroll.tmpl", data)
+		return
+	}
+
+	err = app.sessionStore.SetEnrolled(r.Context(), qrAcc)
+	app.audit(r, "enroll", qrAcc, err)
+	if err != nil {
+		app.logger.Error("failed to save enrollment", "acc", qrAcc, slog.Any("error", err))
 	}
 
+	// revealed QR isn't served anymore
+	app.sessionManager.Remove(r.Context(), "qrRevealedUntil")
+	app.sessionManager.Put(r.Context(), "flash", app.enrollResultMessage(err))
 	http.Redirect(w, r, "/qr/view", http.StatusSeeOther)
 }
 
@@ -280,6 +1094,9 @@ func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
 	app.sessionManager.Remove(r.Context(), "accName")
 	app.sessionManager.Remove(r.Context(), "displayName")
 	app.sessionManager.Remove(r.Context(), "QrAcc")
+	app.sessionManager.Remove(r.Context(), "reissueJobID")
+	app.sessionManager.Remove(r.Context(), "otpDigits")
+	app.sessionManager.Remove(r.Context(), "qrRevealedUntil")
 
 	// Add a flash message to the session to confirm to the user that they've been
 	// logged out.
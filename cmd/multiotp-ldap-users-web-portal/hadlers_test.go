@@ -0,0 +1,562 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image/png"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/jobs"
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/multiotp"
+)
+
+var jobIDRX = regexp.MustCompile(`data-job-id='([0-9a-f]+)'`)
+
+// Reissue job is enqueued, its status is polled until it's done
+func TestQrReissuePost(t *testing.T) {
+	fake := multiotp.NewFake(testAcc)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fake.ReissueFunc = func(ctx context.Context, user string, profile *multiotp.TokenProfile, onStep func(step string)) error {
+		onStep(multiotp.StepDeleting)
+		close(started)
+		<-release
+		onStep(multiotp.StepCreating)
+		return nil
+	}
+
+	app := newTestApplication(t, fake)
+	ts := newTestServer(t, app)
+	ts.login(t)
+
+	code, header, _ := ts.postForm(t, "/qr/reissue", "/qr/test", url.Values{})
+	if code != http.StatusSeeOther || header.Get("Location") != "/qr/view" {
+		t.Fatalf("got %d to %q, want %d to /qr/view", code, header.Get("Location"), http.StatusSeeOther)
+	}
+	<-started
+
+	// active job is shown instead of QR
+	_, _, body := ts.get(t, "/qr/view")
+	matches := jobIDRX.FindStringSubmatch(body)
+	if len(matches) < 2 {
+		t.Fatal("no reissue job on view page")
+	}
+	jobID := matches[1]
+
+	if state := jobState(t, ts, jobID); state != jobs.Deleting {
+		t.Errorf("job state %q, want %q", state, jobs.Deleting)
+	}
+
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for state := jobState(t, ts, jobID); state != jobs.Done; state = jobState(t, ts, jobID) {
+		if time.Now().After(deadline) {
+			t.Fatalf("job isn't done, state %q", state)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// other session's(unknown) job isn't shown
+	if code, _, _ := ts.get(t, "/qr/reissue/0123456789abcdef"); code != http.StatusNotFound {
+		t.Errorf("unknown job: got %d, want %d", code, http.StatusNotFound)
+	}
+
+	if calls := fake.Calls(); !slices.Contains(calls, "Reissue "+testAcc) {
+		t.Errorf("reissue isn't called, calls %q", calls)
+	}
+}
+
+func TestQrReissuePostUnknownProfile(t *testing.T) {
+	fake := multiotp.NewFake(testAcc)
+	app := newTestApplication(t, fake)
+	ts := newTestServer(t, app)
+	ts.login(t)
+
+	code, _, _ := ts.postForm(t, "/qr/reissue", "/qr/test", url.Values{"profile": {"unknown"}})
+	if code != http.StatusSeeOther {
+		t.Fatalf("got %d, want %d", code, http.StatusSeeOther)
+	}
+	if flash := ts.flash(t, "/qr/test"); flash != "Your QR hasn't been reissued!" {
+		t.Errorf("flash %q", flash)
+	}
+	if calls := fake.Calls(); slices.Contains(calls, "Reissue "+testAcc) {
+		t.Error("reissue with unknown profile must not run")
+	}
+}
+
+// Reissue with other profile while user's job is running isn't applied silently
+func TestQrReissuePostOtherProfile(t *testing.T) {
+	fake := multiotp.NewFake(testAcc)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fake.ReissueFunc = func(ctx context.Context, user string, profile *multiotp.TokenProfile, onStep func(step string)) error {
+		close(started)
+		<-release
+		return nil
+	}
+
+	app := newTestApplication(t, fake)
+	app.tokenProfiles = []multiotp.TokenProfile{{Name: "compliance", Type: "totp", Algorithm: "SHA256", Digits: 8, Period: 60}}
+	ts := newTestServer(t, app)
+	ts.login(t)
+	defer close(release)
+
+	ts.postForm(t, "/qr/reissue", "/qr/test", url.Values{})
+	<-started
+
+	code, header, _ := ts.postForm(t, "/qr/reissue", "/qr/test", url.Values{"profile": {"compliance"}})
+	if code != http.StatusSeeOther || header.Get("Location") != "/qr/view" {
+		t.Fatalf("got %d to %q, want %d to /qr/view", code, header.Get("Location"), http.StatusSeeOther)
+	}
+	want := "QR reissue with other token profile is already running, chosen profile hasn't been applied!"
+	if flash := ts.flash(t, "/qr/test"); flash != want {
+		t.Errorf("flash %q, want %q", flash, want)
+	}
+	reissues := slices.DeleteFunc(fake.Calls(), func(call string) bool { return call != "Reissue "+testAcc })
+	if len(reissues) != 1 {
+		t.Errorf("reissue must run once, got %d", len(reissues))
+	}
+}
+
+// Poll reissue job status
+func jobState(t *testing.T, ts *testServer, id string) jobs.State {
+	t.Helper()
+
+	code, _, body := ts.get(t, "/qr/reissue/"+id)
+	if code != http.StatusOK {
+		t.Fatalf("job status: got %d, want %d", code, http.StatusOK)
+	}
+
+	var job jobs.Job
+	if err := json.Unmarshal([]byte(body), &job); err != nil {
+		t.Fatal(err)
+	}
+	return job.State
+}
+
+// OTP checks are limited per account(3 per hour in test app)
+func TestQrTestPost(t *testing.T) {
+	fake := multiotp.NewFake(testAcc)
+	fake.CheckOTPFunc = func(ctx context.Context, user, otp string) error {
+		if otp != "123456" {
+			return &multiotp.ExitError{Command: "check", Code: 99}
+		}
+		return nil
+	}
+
+	app := newTestApplication(t, fake)
+	ts := newTestServer(t, app)
+	ts.login(t)
+
+	tests := []struct {
+		name     string
+		otp      string
+		wantCode int
+		wantBody string
+	}{
+		{"valid OTP", "123456", http.StatusSeeOther, ""},
+		{"wrong OTP", "654321", http.StatusUnprocessableEntity, "Your code is wrong!"},
+		{"invalid OTP", "12ab", http.StatusUnprocessableEntity, "OTP is not valid"},
+		{"valid OTP again", "123456", http.StatusSeeOther, ""},
+		{"limited", "123456", http.StatusTooManyRequests, "Too many attempts"},
+	}
+
+	for _, tt := range tests {
+		code, header, body := ts.postForm(t, "/qr/test", "/qr/test", url.Values{"otp": {tt.otp}})
+		if code != tt.wantCode {
+			t.Errorf("%s: got %d, want %d", tt.name, code, tt.wantCode)
+		}
+		if !strings.Contains(body, tt.wantBody) {
+			t.Errorf("%s: body doesn't contain %q", tt.name, tt.wantBody)
+		}
+		if code == http.StatusTooManyRequests && len(header.Get("Retry-After")) == 0 {
+			t.Errorf("%s: no Retry-After header", tt.name)
+		}
+	}
+
+	// invalid OTP isn't checked, so 3 checks are made
+	checks := 0
+	for _, call := range fake.Calls() {
+		if call == "CheckOTP "+testAcc {
+			checks++
+		}
+	}
+	if checks != 3 {
+		t.Errorf("got %d OTP checks, want 3", checks)
+	}
+}
+
+// Resync result is flashed, invalid OTPs aren't sent to MultiOTP, resyncs are limited per account(3 per hour in test app)
+func TestQrResyncPost(t *testing.T) {
+	fake := multiotp.NewFake(testAcc)
+	fake.ResyncFunc = func(ctx context.Context, user, otp1, otp2 string) error {
+		if otp1 != "111111" || otp2 != "222222" {
+			return &multiotp.ExitError{Command: "-resync", Code: 27}
+		}
+		return nil
+	}
+
+	app := newTestApplication(t, fake)
+	ts := newTestServer(t, app)
+	ts.login(t)
+
+	tests := []struct {
+		name      string
+		otp1      string
+		otp2      string
+		wantCode  int
+		wantBody  string
+		wantFlash string
+	}{
+		{"resynced", "111111", "222222", http.StatusSeeOther, "", "Your token has been resynchronized!"},
+		{"codes don't match", "333333", "444444", http.StatusSeeOther, "", "Your token hasn't been resynchronized: codes don't match, enter two consecutive codes!"},
+		{"invalid OTP", "12ab", "222222", http.StatusUnprocessableEntity, "OTP is not valid", ""},
+		{"blank OTP", "111111", "", http.StatusUnprocessableEntity, "This field cannot be blank", ""},
+		{"same codes", "111111", "111111", http.StatusUnprocessableEntity, "Codes must be different", ""},
+		{"resynced again", "111111", "222222", http.StatusSeeOther, "", "Your token has been resynchronized!"},
+		{"limited", "111111", "222222", http.StatusTooManyRequests, "Too many attempts", ""},
+	}
+
+	for _, tt := range tests {
+		code, header, body := ts.postForm(t, "/qr/resync", "/qr/resync", url.Values{"otp1": {tt.otp1}, "otp2": {tt.otp2}})
+		if code != tt.wantCode {
+			t.Errorf("%s: got %d, want %d", tt.name, code, tt.wantCode)
+		}
+		if !strings.Contains(body, tt.wantBody) {
+			t.Errorf("%s: body doesn't contain %q", tt.name, tt.wantBody)
+		}
+		if code == http.StatusTooManyRequests && len(header.Get("Retry-After")) == 0 {
+			t.Errorf("%s: no Retry-After header", tt.name)
+		}
+		if code == http.StatusSeeOther {
+			if header.Get("Location") != "/qr/view" {
+				t.Errorf("%s: redirect to %q, want /qr/view", tt.name, header.Get("Location"))
+			}
+			if flash := ts.flash(t, "/qr/resync"); flash != tt.wantFlash {
+				t.Errorf("%s: flash %q, want %q", tt.name, flash, tt.wantFlash)
+			}
+		}
+	}
+
+	// invalid forms aren't sent, limited one isn't either
+	resyncs := 0
+	for _, call := range fake.Calls() {
+		if call == "Resync "+testAcc {
+			resyncs++
+		}
+	}
+	if resyncs != 3 {
+		t.Errorf("got %d resyncs, want 3", resyncs)
+	}
+}
+
+// QR png & print page are served only for qrReveal time after "Show QR"(/test/reveal)
+func TestQrImageReveal(t *testing.T) {
+	app := newTestApplication(t, multiotp.NewFake(testAcc))
+	// reveal time is kept in unix seconds
+	app.qrReveal = 2 * time.Second
+	ts := newTestServer(t, app)
+	ts.login(t)
+
+	checkHidden := func(stage string) {
+		t.Helper()
+
+		if code, _, _ := ts.get(t, "/qr/image.png"); code != http.StatusForbidden {
+			t.Errorf("QR png %s: got %d, want %d", stage, code, http.StatusForbidden)
+		}
+		code, header, _ := ts.get(t, "/qr/print")
+		if code != http.StatusSeeOther || header.Get("Location") != "/qr/view" {
+			t.Errorf("print page %s: got %d to %q, want %d to /qr/view", stage, code, header.Get("Location"), http.StatusSeeOther)
+		}
+	}
+
+	checkHidden("before reveal")
+
+	ts.reveal(t)
+
+	code, header, img := ts.get(t, "/qr/image.png")
+	if code != http.StatusOK {
+		t.Errorf("QR png after reveal: got %d, want %d", code, http.StatusOK)
+	}
+	if _, err := png.Decode(strings.NewReader(img)); err != nil {
+		t.Errorf("QR png after reveal: %v", err)
+	}
+	if header.Get("Cache-Control") != "no-store" {
+		t.Errorf("QR png is cacheable: Cache-Control %q", header.Get("Cache-Control"))
+	}
+	if code, _, _ := ts.get(t, "/qr/print"); code != http.StatusOK {
+		t.Errorf("print page after reveal: got %d, want %d", code, http.StatusOK)
+	}
+
+	time.Sleep(app.qrReveal)
+	checkHidden("after reveal time")
+}
+
+func TestQrUnlockPost(t *testing.T) {
+	fake := multiotp.NewFake(testAcc)
+	locked := "1"
+	fake.UserInfoFunc = func(ctx context.Context, user string) (multiotp.UserInfo, error) {
+		return multiotp.UserInfo{"user": user, "locked": locked}, nil
+	}
+
+	app := newTestApplication(t, fake)
+	ts := newTestServer(t, app)
+	ts.login(t)
+
+	code, _, body := ts.postForm(t, "/qr/unlock", "/qr/unlock", url.Values{"password": {"wrong"}})
+	if code != http.StatusUnprocessableEntity || !strings.Contains(body, "Wrong password") {
+		t.Errorf("wrong password: got %d, want %d with error", code, http.StatusUnprocessableEntity)
+	}
+
+	code, _, body = ts.postForm(t, "/qr/unlock", "/qr/unlock", url.Values{"password": {""}})
+	if code != http.StatusUnprocessableEntity || !strings.Contains(body, "This field cannot be blank") {
+		t.Errorf("blank password: got %d, want %d with error", code, http.StatusUnprocessableEntity)
+	}
+
+	// 2 unlocks per day in test app
+	for range 2 {
+		code, _, _ = ts.postForm(t, "/qr/unlock", "/qr/unlock", url.Values{"password": {testPassword}})
+		if code != http.StatusSeeOther {
+			t.Fatalf("unlock: got %d, want %d", code, http.StatusSeeOther)
+		}
+		if flash := ts.flash(t, "/qr/test"); flash != "Your token has been unlocked!" {
+			t.Errorf("flash %q", flash)
+		}
+	}
+
+	code, _, body = ts.postForm(t, "/qr/unlock", "/qr/unlock", url.Values{"password": {testPassword}})
+	if code != http.StatusTooManyRequests || !strings.Contains(body, "Too many unlocks in 24h") {
+		t.Errorf("unlocks limit: got %d, want %d", code, http.StatusTooManyRequests)
+	}
+
+	// not locked token isn't unlocked
+	locked = "0"
+	code, _, _ = ts.postForm(t, "/qr/unlock", "/qr/unlock", url.Values{"password": {testPassword}})
+	if code != http.StatusSeeOther {
+		t.Fatalf("not locked: got %d, want %d", code, http.StatusSeeOther)
+	}
+	if flash := ts.flash(t, "/qr/test"); flash != "Your token isn't locked" {
+		t.Errorf("flash %q", flash)
+	}
+
+	unlocks := 0
+	for _, call := range fake.Calls() {
+		if call == "UnlockUser "+testAcc {
+			unlocks++
+		}
+	}
+	if unlocks != 2 {
+		t.Errorf("got %d unlocks, want 2", unlocks)
+	}
+}
+
+// password re-entries are limited before LDAP bind(5 per hour in test app)
+func TestQrUnlockPostPasswordLimit(t *testing.T) {
+	fake := multiotp.NewFake(testAcc)
+	fake.UserInfoFunc = func(ctx context.Context, user string) (multiotp.UserInfo, error) {
+		return multiotp.UserInfo{"user": user, "locked": "1"}, nil
+	}
+
+	app := newTestApplication(t, fake)
+	checks := countPasswordChecks(app)
+	ts := newTestServer(t, app)
+	ts.login(t)
+
+	for range 5 {
+		code, _, _ := ts.postForm(t, "/qr/unlock", "/qr/unlock", url.Values{"password": {"wrong"}})
+		if code != http.StatusUnprocessableEntity {
+			t.Fatalf("wrong password: got %d, want %d", code, http.StatusUnprocessableEntity)
+		}
+	}
+
+	code, header, body := ts.postForm(t, "/qr/unlock", "/qr/unlock", url.Values{"password": {testPassword}})
+	if code != http.StatusTooManyRequests || !strings.Contains(body, "Too many attempts") {
+		t.Errorf("password limit: got %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if len(header.Get("Retry-After")) == 0 {
+		t.Error("no Retry-After header")
+	}
+	if *checks != 5 {
+		t.Errorf("got %d password checks, want 5", *checks)
+	}
+	if calls := fake.Calls(); slices.Contains(calls, "UnlockUser "+testAcc) {
+		t.Error("token is unlocked over password limit")
+	}
+}
+
+// password re-entries on secret page share the limit, every failure is audited
+func TestQrSecretPostPasswordLimit(t *testing.T) {
+	fake := multiotp.NewFake(testAcc)
+	fake.UserInfoFunc = func(ctx context.Context, user string) (multiotp.UserInfo, error) {
+		return multiotp.UserInfo{"user": user, "locked": "1"}, nil
+	}
+	app := newTestApplication(t, fake)
+	var logs bytes.Buffer
+	app.logger = slog.New(slog.NewJSONHandler(&logs, nil))
+	checks := countPasswordChecks(app)
+	ts := newTestServer(t, app)
+	ts.login(t)
+
+	code, _, _ := ts.postForm(t, "/qr/secret", "/qr/secret", url.Values{"password": {testPassword}})
+	if code != http.StatusOK {
+		t.Fatalf("valid password: got %d, want %d", code, http.StatusOK)
+	}
+
+	for range 4 {
+		code, _, _ := ts.postForm(t, "/qr/secret", "/qr/secret", url.Values{"password": {"wrong"}})
+		if code != http.StatusUnprocessableEntity {
+			t.Fatalf("wrong password: got %d, want %d", code, http.StatusUnprocessableEntity)
+		}
+	}
+
+	code, header, body := ts.postForm(t, "/qr/secret", "/qr/secret", url.Values{"password": {testPassword}})
+	if code != http.StatusTooManyRequests || !strings.Contains(body, "Too many attempts") {
+		t.Errorf("password limit: got %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if len(header.Get("Retry-After")) == 0 {
+		t.Error("no Retry-After header")
+	}
+	if *checks != 5 {
+		t.Errorf("got %d password checks, want 5", *checks)
+	}
+
+	// limit is shared with self-unlock
+	code, _, _ = ts.postForm(t, "/qr/unlock", "/qr/unlock", url.Values{"password": {testPassword}})
+	if code != http.StatusTooManyRequests {
+		t.Errorf("unlock over password limit: got %d, want %d", code, http.StatusTooManyRequests)
+	}
+
+	// 1 success, 4 wrong passwords, 1 limited
+	results := make(map[string]int)
+	dec := json.NewDecoder(&logs)
+	for {
+		var record struct {
+			Msg    string `json:"msg"`
+			Action string `json:"action"`
+			Result string `json:"result"`
+		}
+		if err := dec.Decode(&record); err != nil {
+			break
+		}
+		if record.Msg == "audit" && record.Action == "secret" {
+			results[record.Result]++
+		}
+	}
+	if results["success"] != 1 || results["failure"] != 5 {
+		t.Errorf("audited secret attempts %v, want 1 success & 5 failures", results)
+	}
+}
+
+// Count calls of app's password check
+func countPasswordChecks(app *application) *int {
+	checks := 0
+	checkPassword := app.checkPassword
+	app.checkPassword = func(login, password string) error {
+		checks++
+		return checkPassword(login, password)
+	}
+	return &checks
+}
+
+func TestQrEnrollPost(t *testing.T) {
+	fake := multiotp.NewFake(testAcc)
+	fake.CheckOTPFunc = func(ctx context.Context, user, otp string) error {
+		if otp != "123456" {
+			return &multiotp.ExitError{Command: "check", Code: 99}
+		}
+		return nil
+	}
+
+	app := newTestApplication(t, fake)
+	ts := newTestServer(t, app)
+	ts.login(t)
+
+	// enrollmentMode is off
+	if code, _, _ := ts.get(t, "/qr/enroll"); code != http.StatusNotFound {
+		t.Errorf("enrollmentMode off: got %d, want %d", code, http.StatusNotFound)
+	}
+
+	app.enrollmentMode = true
+
+	code, _, body := ts.postForm(t, "/qr/enroll", "/qr/enroll", url.Values{"otp": {"654321"}})
+	if code != http.StatusUnprocessableEntity || !strings.Contains(body, "Your code is wrong!") {
+		t.Errorf("wrong OTP: got %d, want %d with error", code, http.StatusUnprocessableEntity)
+	}
+	if enrolled, _ := app.sessionStore.Enrolled(context.Background(), testAcc); enrolled {
+		t.Fatal("enrolled with wrong OTP")
+	}
+
+	// QR png is served before enrollment(after "Show QR")
+	ts.reveal(t)
+	if code, _, _ := ts.get(t, "/qr/image.png"); code != http.StatusOK {
+		t.Errorf("QR png before enrollment: got %d, want %d", code, http.StatusOK)
+	}
+
+	code, _, _ = ts.postForm(t, "/qr/enroll", "/qr/enroll", url.Values{"otp": {"123456"}})
+	if code != http.StatusSeeOther {
+		t.Fatalf("valid OTP: got %d, want %d", code, http.StatusSeeOther)
+	}
+	if flash := ts.flash(t, "/qr/test"); flash != "Your token enrollment is confirmed!" {
+		t.Errorf("flash %q", flash)
+	}
+	if enrolled, _ := app.sessionStore.Enrolled(context.Background(), testAcc); !enrolled {
+		t.Fatal("not enrolled with valid OTP")
+	}
+
+	// QR & secret aren't shown after enrollment
+	ts.reveal(t)
+	if code, _, _ := ts.get(t, "/qr/image.png"); code != http.StatusForbidden {
+		t.Errorf("QR png: got %d, want %d", code, http.StatusForbidden)
+	}
+	if code, header, _ := ts.get(t, "/qr/secret"); code != http.StatusSeeOther || header.Get("Location") != "/qr/view" {
+		t.Errorf("secret: got %d, want %d to /qr/view", code, http.StatusSeeOther)
+	}
+}
+
+// enrollment marker is reset once old token is deleted, even if create fails
+func TestQrReissueResetsEnrollment(t *testing.T) {
+	tests := []struct {
+		name         string
+		steps        []string
+		wantEnrolled bool
+	}{
+		{"delete failed", []string{multiotp.StepDeleting}, true},
+		{"create failed", []string{multiotp.StepDeleting, multiotp.StepCreating}, false},
+		{"sync failed", []string{multiotp.StepDeleting, multiotp.StepSyncing}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := multiotp.NewFake(testAcc)
+			fake.ReissueFunc = func(ctx context.Context, user string, profile *multiotp.TokenProfile, onStep func(step string)) error {
+				for _, step := range tt.steps {
+					onStep(step)
+				}
+				return &multiotp.ExitError{Command: "-delete", Code: 28}
+			}
+
+			app := newTestApplication(t, fake)
+			app.enrollmentMode = true
+			ctx := context.Background()
+			if err := app.sessionStore.SetEnrolled(ctx, testAcc); err != nil {
+				t.Fatal(err)
+			}
+
+			err := app.runReissueJob(ctx, testAcc, "", func(jobs.State) {})
+			if err == nil {
+				t.Fatal("failed reissue must return error")
+			}
+			if enrolled, _ := app.sessionStore.Enrolled(ctx, testAcc); enrolled != tt.wantEnrolled {
+				t.Errorf("enrolled %t, want %t", enrolled, tt.wantEnrolled)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	ldapwork "github.com/slayerjk/go-valdapwork"
+)
+
+// readiness results are cached, so probes can't hammer LDAP
+const (
+	readyCacheTTL = 5 * time.Second
+	readyTimeout  = 5 * time.Second
+)
+
+// Result of one dependency check
+type checkResult struct {
+	Status    string  `json:"status"` // "ok" or "fail"
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Result of all checks
+type readyResult struct {
+	Status    string                 `json:"status"` // "ok" if all checks are ok
+	CheckedAt time.Time              `json:"checkedAt"`
+	Checks    map[string]checkResult `json:"checks"`
+}
+
+// readiness holds cached readiness result.
+// Only one check run at a time, other requests wait for it.
+type readiness struct {
+	mu     sync.Mutex
+	result *readyResult
+}
+
+// Liveness probe: process is alive and serves HTTP
+func (app *application) healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte(`{"status":"ok"}`))
+}
+
+// Readiness probe: all dependencies are reachable
+func (app *application) readyz(w http.ResponseWriter, r *http.Request) {
+	result := app.readiness.get(app.checkDependencies)
+
+	status := http.StatusOK
+	if result.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
+
+// return cached result or run checks if cache is expired
+func (rd *readiness) get(check func() *readyResult) *readyResult {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+
+	if rd.result == nil || time.Since(rd.result.CheckedAt) > readyCacheTTL {
+		rd.result = check()
+	}
+
+	return rd.result
+}
+
+// run all dependency checks in parallel
+func (app *application) checkDependencies() *readyResult {
+	ctx, cancel := context.WithTimeout(context.Background(), readyTimeout)
+	defer cancel()
+
+	domain := app.domain.Load()
+
+	checks := map[string]func(context.Context) error{
+		"sessionStore": app.sessionStore.Check,
+		"userDomainLDAP": func(ctx context.Context) error {
+			return app.checkLDAP(ctx, domain.userDomainFQDN)
+		},
+		"qrDomainLDAP": func(ctx context.Context) error {
+			return app.checkLDAP(ctx, domain.qrDomainFQDN)
+		},
+		"multiOTP": func(ctx context.Context) error {
+			return app.multiOTP.Check(ctx)
+		},
+	}
+	if *app.secondFactorOn {
+		checks["privacyIdea"] = func(ctx context.Context) error {
+			return checkHTTP(ctx, domain.mfaClient, domain.mfaUrl)
+		}
+	}
+
+	result := &readyResult{
+		Status: "ok",
+		Checks: make(map[string]checkResult, len(checks)),
+	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			start := time.Now()
+			err := check(ctx)
+			res := checkResult{
+				Status:    "ok",
+				LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				res.Status = "fail"
+				res.Error = err.Error()
+			}
+
+			mu.Lock()
+			result.Checks[name] = res
+			if err != nil {
+				result.Status = "fail"
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	result.CheckedAt = time.Now()
+	if result.Status != "ok" {
+		app.logger.Warn("readiness check failed", "checks", result.Checks)
+	}
+
+	return result
+}
+
+// LDAP TLS connect(the same way handlers do), limited by ctx
+func checkLDAPConn(ctx context.Context, fqdn string) error {
+	done := make(chan error, 1)
+	go func() {
+		conn, err := ldapwork.StartTLSConnWoVerification(fqdn)
+		if err == nil {
+			conn.Close()
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// any HTTP response means service is reachable,
+// httpClient is the one of mfaAuth(same TLS verification, see mfaCAFile)
+func checkHTTP(ctx context.Context, httpClient *http.Client, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/multiotp"
+)
+
+// GET /readyz, returns status code & decoded result
+func getReady(t *testing.T, ts *testServer) (int, readyResult) {
+	t.Helper()
+
+	code, _, body := ts.get(t, "/readyz")
+	var result readyResult
+	if err := json.Unmarshal([]byte(body), &result); err != nil {
+		t.Fatalf("readyz: %v\n%s", err, body)
+	}
+	return code, result
+}
+
+func TestHealthz(t *testing.T) {
+	ts := newTestServer(t, newTestApplication(t, multiotp.NewFake()))
+
+	code, header, body := ts.get(t, "/healthz")
+	if code != http.StatusOK || body != `{"status":"ok"}` {
+		t.Errorf("got %d %q, want %d {\"status\":\"ok\"}", code, body, http.StatusOK)
+	}
+	if header.Get("Cache-Control") != "no-store" {
+		t.Errorf("Cache-Control %q, want no-store", header.Get("Cache-Control"))
+	}
+}
+
+// failed dependency makes portal not ready, other checks are reported as is
+func TestReadyzFailure(t *testing.T) {
+	fake := multiotp.NewFake()
+	fake.CheckFunc = func(ctx context.Context) error {
+		return errors.New("multiotp -version: exit status 1")
+	}
+	ts := newTestServer(t, newTestApplication(t, fake))
+
+	code, result := getReady(t, ts)
+	if code != http.StatusServiceUnavailable || result.Status != "fail" {
+		t.Errorf("got %d %q, want %d fail", code, result.Status, http.StatusServiceUnavailable)
+	}
+
+	want := map[string]string{"sessionStore": "ok", "userDomainLDAP": "ok", "qrDomainLDAP": "ok", "multiOTP": "fail"}
+	if len(result.Checks) != len(want) {
+		t.Errorf("checks %v, want %v", result.Checks, want)
+	}
+	for name, status := range want {
+		if result.Checks[name].Status != status {
+			t.Errorf("%s: got %q, want %q", name, result.Checks[name].Status, status)
+		}
+	}
+	if msg := result.Checks["multiOTP"].Error; !strings.Contains(msg, "exit status 1") {
+		t.Errorf("multiOTP error %q isn't reported", msg)
+	}
+}
+
+// result is cached for readyCacheTTL, so probes don't hammer dependencies
+func TestReadyzCache(t *testing.T) {
+	var checks atomic.Int32
+	fake := multiotp.NewFake()
+	fake.CheckFunc = func(ctx context.Context) error {
+		checks.Add(1)
+		return nil
+	}
+	app := newTestApplication(t, fake)
+	ts := newTestServer(t, app)
+
+	for range 3 {
+		if code, _ := getReady(t, ts); code != http.StatusOK {
+			t.Errorf("got %d, want %d", code, http.StatusOK)
+		}
+	}
+	if n := checks.Load(); n != 1 {
+		t.Errorf("%d checks in cache TTL, want 1", n)
+	}
+
+	// cache expires
+	app.readiness.mu.Lock()
+	app.readiness.result.CheckedAt = time.Now().Add(-readyCacheTTL - time.Second)
+	app.readiness.mu.Unlock()
+
+	getReady(t, ts)
+	if n := checks.Load(); n != 2 {
+		t.Errorf("%d checks after cache TTL, want 2", n)
+	}
+}
+
+// every check waits for all others to start: sequential checks would time out
+func TestReadyzParallel(t *testing.T) {
+	var started sync.WaitGroup
+	allStarted := make(chan struct{})
+	wait := func(ctx context.Context) error {
+		started.Done()
+		select {
+		case <-allStarted:
+			return nil
+		case <-ctx.Done():
+			return errors.New("checks aren't run in parallel")
+		}
+	}
+
+	// LDAP of both domains & MultiOTP
+	started.Add(3)
+	go func() {
+		started.Wait()
+		close(allStarted)
+	}()
+
+	fake := multiotp.NewFake()
+	fake.CheckFunc = wait
+	app := newTestApplication(t, fake)
+	app.checkLDAP = func(ctx context.Context, fqdn string) error {
+		return wait(ctx)
+	}
+	ts := newTestServer(t, app)
+
+	if code, result := getReady(t, ts); code != http.StatusOK {
+		t.Errorf("got %d, want %d: %v", code, http.StatusOK, result.Checks)
+	}
+}
+
+// PrivacyIdea check(2fa on) verifies TLS cert by mfaCAFile, without it cert isn't verified
+func TestReadyzPrivacyIdea(t *testing.T) {
+	pideaServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(pideaServer.Close)
+
+	tests := []struct {
+		name   string
+		caFile string
+		want   string
+	}{
+		{"no CA file", "", "ok"},
+		{"PrivacyIdea CA", writeCertFile(t, pideaServer.Certificate().Raw), "ok"},
+		{"other CA", writeCertFile(t, newTestCert(t)), "fail"},
+	}
+
+	for _, tt := range tests {
+		mfaClient, err := newMfaHTTPClient(tt.caFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		app := newTestApplication(t, multiotp.NewFake())
+		*app.secondFactorOn = true
+		app.domain.Store(&domainData{mfaUrl: pideaServer.URL, mfaClient: mfaClient})
+
+		_, result := getReady(t, newTestServer(t, app))
+		check := result.Checks["privacyIdea"]
+		if check.Status != tt.want {
+			t.Errorf("%s: got %q(%s), want %q", tt.name, check.Status, check.Error, tt.want)
+		}
+		if tt.want == "fail" && !strings.Contains(check.Error, "certificate") {
+			t.Errorf("%s: got %q, want certificate error", tt.name, check.Error)
+		}
+	}
+
+	if _, err := newMfaHTTPClient(filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Error("missing CA file must fail")
+	}
+}
+
+// write DER cert to PEM file
+func writeCertFile(t *testing.T, der []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(path, certPEM, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// self-signed CA cert(DER), httptest servers share the same cert
+func newTestCert(t *testing.T) []byte {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "other CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
@@ -3,15 +3,23 @@ package main
 import (
 	"bytes"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/go-playground/form/v4"
 	"github.com/justinas/nosurf"
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/jobs"
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/metrics"
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/multiotp"
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/otpauth"
 
 	pidea "github.com/slayerjk/go-pideaapi"
+	ldapwork "github.com/slayerjk/go-valdapwork"
 )
 
 // The serverError helper writes a log entry at Error level (including the request
@@ -27,6 +35,22 @@ func (app *application) serverError(w http.ResponseWriter, r *http.Request, err
 	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }
 
+// The sessionStoreError helper is used as scs ErrorFunc: session store failed to
+// load or save session(ex. session db is down). Session data is not available
+// here, so "service unavailable" page is rendered without it.
+func (app *application) sessionStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Error("session store failed", "method", r.Method, "uri", r.URL.RequestURI(), slog.Any("error", err))
+
+	data := templateData{
+		CurrentYear:    time.Now().Year(),
+		SecondFactorOn: *app.secondFactorOn,
+	}
+
+	w.Header().Set("Retry-After", "30")
+	w.Header().Set("Cache-Control", "no-store")
+	app.render(w, r, http.StatusServiceUnavailable, "unavailable.tmpl", data)
+}
+
 // The clientError helper sends a specific status code and corresponding description
 // to the user. We'll use this later in the book to send responses like 400 "Bad
 // Request" when there's a problem with the request that the user sent.
@@ -48,6 +72,11 @@ func (app *application) newTemplateData(r *http.Request) templateData {
 		SecondFactorOn:  false,
 	}
 
+	// token profiles to choose on reissue
+	for _, profile := range app.tokenProfiles {
+		template.TokenProfiles = append(template.TokenProfiles, profile.Name)
+	}
+
 	// check if '2fa' flag is ON
 	if *app.secondFactorOn {
 		template.SecondFactorOn = true
@@ -122,6 +151,254 @@ func (app *application) decodePostForm(r *http.Request, dst any) error {
 	return nil
 }
 
+// Localized reissue job result message
+func (app *application) reissueResultMessage(state jobs.State) string {
+	if state == jobs.Done {
+		if *app.lang == "en" {
+			return "Your QR has been reissued!"
+		}
+		return "Ваш QR перевыпущен!"
+	}
+
+	if *app.lang == "en" {
+		return "Your QR hasn't been reissued!"
+	}
+	return "Ваш QR НЕ перевыпущен!"
+}
+
+// Localized token resync result for flash message
+func (app *application) resyncResultMessage(err error) string {
+	switch {
+	case err == nil:
+		if *app.lang == "en" {
+			return "Your token has been resynchronized!"
+		}
+		return "Ваш токен синхронизирован!"
+	case errors.Is(err, multiotp.ErrResyncFailed):
+		if *app.lang == "en" {
+			return "Your token hasn't been resynchronized: codes don't match, enter two consecutive codes!"
+		}
+		return "Ваш токен НЕ синхронизирован: коды не подходят, введите два последовательных кода!"
+	case errors.Is(err, multiotp.ErrTokenLocked):
+		if *app.lang == "en" {
+			return "Your token hasn't been resynchronized: token is locked!"
+		}
+		return "Ваш токен НЕ синхронизирован: токен заблокирован!"
+	}
+
+	if *app.lang == "en" {
+		return "Your token hasn't been resynchronized!"
+	}
+	return "Ваш токен НЕ синхронизирован!"
+}
+
+// Localized OTP test result
+func (app *application) otpTestResultMessage(err error) string {
+	switch {
+	case err == nil:
+		if *app.lang == "en" {
+			return "Your code is correct, your token works!"
+		}
+		return "Ваш код верный, токен работает!"
+	case errors.Is(err, multiotp.ErrAuthFailed):
+		if *app.lang == "en" {
+			return "Your code is wrong! Check you've scanned your current QR, or resync your token."
+		}
+		return "Ваш код неверный! Проверьте, что отсканирован текущий QR, или синхронизируйте токен."
+	case errors.Is(err, multiotp.ErrTokenUsed):
+		if *app.lang == "en" {
+			return "This code has already been used, wait for the next one."
+		}
+		return "Этот код уже использован, дождитесь следующего."
+	case errors.Is(err, multiotp.ErrTokenLocked), errors.Is(err, multiotp.ErrTokenDelayed):
+		if *app.lang == "en" {
+			return "Your token is locked or delayed after failed attempts, try later."
+		}
+		return "Ваш токен заблокирован или задержан после неудачных попыток, попробуйте позже."
+	}
+
+	if *app.lang == "en" {
+		return "Your code hasn't been checked, try later."
+	}
+	return "Ваш код НЕ проверен, попробуйте позже."
+}
+
+// Localized rate limit message(OTP test, password re-entry)
+func (app *application) attemptsLimitMessage(wait time.Duration) string {
+	minutes := int(wait.Minutes()) + 1
+	if *app.lang == "en" {
+		return fmt.Sprintf("Too many attempts, try again in %d min.", minutes)
+	}
+	return fmt.Sprintf("Слишком много попыток, попробуйте через %d мин.", minutes)
+}
+
+// Token profile by name, nil if there is no such profile(or name is empty)
+func (app *application) tokenProfile(name string) *multiotp.TokenProfile {
+	for i := range app.tokenProfiles {
+		if app.tokenProfiles[i].Name == name {
+			return &app.tokenProfiles[i]
+		}
+	}
+	return nil
+}
+
+// Digits of user's token(saved by qrView), default if unknown
+func (app *application) otpDigits(r *http.Request) int {
+	digits := app.sessionManager.GetInt(r.Context(), "otpDigits")
+	if digits == 0 {
+		return otpauth.DefaultDigits
+	}
+	return digits
+}
+
+// Parse user's otpauth URL and apply configured issuer, label & image(tokenIssuer, tokenLabel, tokenImage)
+func (app *application) tokenKey(r *http.Request, qrAcc, totpURL string) (*otpauth.Key, error) {
+	key, err := otpauth.Parse(totpURL)
+	if err != nil {
+		return nil, err
+	}
+
+	displayName := app.sessionManager.GetString(r.Context(), "displayName")
+	if len(displayName) == 0 {
+		displayName = app.sessionManager.GetString(r.Context(), "accName")
+	}
+	err = app.tokenRewrite.Apply(key, otpauth.LabelData{
+		otpauth.LabelSAMAccountName: qrAcc,
+		otpauth.LabelDisplayName:    displayName,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// LDAP of user domain is unavailable(not a wrong password)
+var errLDAPUnavailable = errors.New("user domain LDAP is unavailable")
+
+// Check user's password again(LDAP bind to userDomainFQDN, the same way login does),
+// used to confirm sensitive self-service actions.
+// errLDAPUnavailable is returned if LDAP can't be reached.
+func (app *application) checkUserPassword(login, password string) error {
+	// current domain data(may be changed on config reload)
+	domain := app.domain.Load()
+
+	start := time.Now()
+	ldapConn, err := ldapwork.StartTLSConnWoVerification(domain.userDomainFQDN)
+	metrics.ObserveLDAP(domain.userDomainFQDN, "connect", start, err)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errLDAPUnavailable, err)
+	}
+	defer ldapConn.Close()
+
+	start = time.Now()
+	err = ldapwork.LdapBind(ldapConn, login+"@"+domain.userDomainFQDN, password)
+	metrics.ObserveLDAP(domain.userDomainFQDN, "bind", start, err)
+
+	return err
+}
+
+// Audit record of user's self-service action(ex. "unlock"),
+// logged with "audit" attr to filter audit records out of the log
+func (app *application) audit(r *http.Request, action, acc string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+
+	app.logger.Info("audit",
+		"audit", true,
+		"action", action,
+		"acc", acc,
+		"login", app.sessionManager.GetString(r.Context(), "accName"),
+		"remoteAddr", r.RemoteAddr,
+		"result", result,
+		slog.Any("error", err),
+	)
+}
+
+// Localized "self-unlock is disabled" message
+func (app *application) unlockDisabledMessage() string {
+	if *app.lang == "en" {
+		return "Self-unlock is disabled, contact your helpdesk!"
+	}
+	return "Самостоятельная разблокировка отключена, обратитесь в службу поддержки!"
+}
+
+// Localized self-unlock result
+func (app *application) unlockResultMessage(err error) string {
+	if err == nil {
+		if *app.lang == "en" {
+			return "Your token has been unlocked!"
+		}
+		return "Ваш токен разблокирован!"
+	}
+
+	if *app.lang == "en" {
+		return "Your token hasn't been unlocked!"
+	}
+	return "Ваш токен НЕ разблокирован!"
+}
+
+// QR was revealed("Show QR") less than qrReveal time ago
+func (app *application) qrRevealed(r *http.Request) bool {
+	return time.Now().Unix() < app.sessionManager.GetInt64(r.Context(), "qrRevealedUntil")
+}
+
+// User has confirmed enrollment(enrollmentMode only), their QR & secret aren't shown.
+// Failed marker check is treated as enrolled: the secret isn't shown if it's unknown.
+func (app *application) enrolled(r *http.Request, qrAcc string) bool {
+	if !app.enrollmentMode {
+		return false
+	}
+
+	enrolled, err := app.sessionStore.Enrolled(r.Context(), qrAcc)
+	if err != nil {
+		app.logger.Error("failed to check enrollment", "acc", qrAcc, slog.Any("error", err))
+		return true
+	}
+	return enrolled
+}
+
+// Localized "QR isn't shown after enrollment" message
+func (app *application) enrolledMessage() string {
+	if *app.lang == "en" {
+		return "Your token is enrolled, its QR isn't shown anymore. Reissue QR to get a new one!"
+	}
+	return "Ваш токен подключен, его QR больше не показывается. Для получения нового перевыпустите QR!"
+}
+
+// Localized enrollment confirmation result
+func (app *application) enrollResultMessage(err error) string {
+	if err == nil {
+		if *app.lang == "en" {
+			return "Your token enrollment is confirmed!"
+		}
+		return "Подключение Вашего токена подтверждено!"
+	}
+
+	if *app.lang == "en" {
+		return "Your code is correct, but enrollment hasn't been saved, try later."
+	}
+	return "Ваш код верный, но подключение НЕ сохранено, попробуйте позже."
+}
+
+// Localized "QR is hidden" message
+func (app *application) qrHiddenMessage() string {
+	if *app.lang == "en" {
+		return "Your QR is hidden, click \"Show QR\" first!"
+	}
+	return "Ваш QR скрыт, сначала нажмите \"Показать QR\"!"
+}
+
+// Localized "secret key can't be shown" message
+func (app *application) secretFailedMessage() string {
+	if *app.lang == "en" {
+		return "Failed to show your secret key!"
+	}
+	return "Не удалось показать Ваш секретный ключ!"
+}
+
 // Return true if the current request is from an authenticated user, otherwise
 // return false.
 func (app *application) isAuthenticated(r *http.Request) bool {
@@ -133,31 +410,75 @@ func (app *application) isAuthenticated(r *http.Request) bool {
 	return isAuthenticated
 }
 
+// PrivacyIdea HTTP client: TLS cert is verified by CA file(mfaCAFile).
+// Without CA file it isn't verified, as before mfaCAFile was added
+// (PrivacyIdea is often deployed with self-signed cert).
+func newMfaHTTPClient(caFile string) (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: true}
+	if len(caFile) != 0 {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read PrivacyIdea CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certs found in PrivacyIdea CA file %s", caFile)
+		}
+		tlsConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}, nil
+}
+
 // PrivacyIdea: Get user's Token Seril and making Valdate check
-func mfaAuth(apiUser, apiUserPass, pideaUrl, realm, user, otp string) (bool, error) {
+func mfaAuth(httpClient *http.Client, apiUser, apiUserPass, pideaUrl, realm, user, otp string) (bool, error) {
 	var result bool = false
 
-	// making httpClient
-	transport := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
-	httpClient := http.Client{Transport: transport}
-
 	// getting API token
-	authToken, err := pidea.GetApiToken(&httpClient, pideaUrl, apiUser, apiUserPass)
+	start := time.Now()
+	authToken, err := pidea.GetApiToken(httpClient, pideaUrl, apiUser, apiUserPass)
+	metrics.ObservePrivacyIdea("get_api_token", start, err)
 	if err != nil {
 		return false, fmt.Errorf("failed to get API token from Pidea: %v; %v", err, authToken)
 	}
 
 	// getting serial
-	serial, err := pidea.GetUserTokenSerial(&httpClient, authToken, pideaUrl, realm, user)
+	start = time.Now()
+	serial, err := pidea.GetUserTokenSerial(httpClient, authToken, pideaUrl, realm, user)
+	metrics.ObservePrivacyIdea("get_user_token_serial", start, err)
 	if err != nil {
 		return false, fmt.Errorf("failed to get user's token serial from Pidea: %v", err)
 	}
 
 	// making validate check
-	result, err = pidea.ValidateCheck(&httpClient, authToken, pideaUrl, realm, user, serial, otp)
+	start = time.Now()
+	result, err = pidea.ValidateCheck(httpClient, authToken, pideaUrl, realm, user, serial, otp)
+	metrics.ObservePrivacyIdea("validate_check", start, err)
 	if err != nil {
 		return false, fmt.Errorf("failed to get Validate check result from Pidea: %v", err)
 	}
 
 	return result, nil
 }
+
+// PrivacyIdea validate check of user's login OTP by current MFA data
+func mfaValidateOTP(domain *domainData, login, otp string) (bool, error) {
+	return mfaAuth(domain.mfaClient, domain.mfaTriggerUser, domain.mfaTriggerUserPass, domain.mfaUrl, domain.userDomainFQDN, login, otp)
+}
+
+// PrivacyIdea has rejected user's OTP(validate check result is false)
+var errOTPRejected = errors.New("OTP is rejected by PrivacyIdea")
+
+// Check user's login OTP(second factor), rejected OTP is errOTPRejected:
+// login is accepted only if PrivacyIdea has confirmed OTP
+func (app *application) checkSecondFactor(domain *domainData, login, otp string) error {
+	valid, err := app.validateOTP(domain, login, otp)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return errOTPRejected
+	}
+
+	return nil
+}
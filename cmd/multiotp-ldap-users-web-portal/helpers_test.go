@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/multiotp"
+)
+
+// login OTP is accepted only if PrivacyIdea confirms it, "false" answer without error is rejection
+func TestCheckSecondFactor(t *testing.T) {
+	errPidea := errors.New("failed to get API token from Pidea")
+
+	tests := []struct {
+		name  string
+		valid bool
+		err   error
+		want  error
+	}{
+		{"confirmed", true, nil, nil},
+		{"rejected", false, nil, errOTPRejected},
+		{"PrivacyIdea error", false, errPidea, errPidea},
+	}
+
+	app := newTestApplication(t, multiotp.NewFake())
+	for _, tt := range tests {
+		app.validateOTP = func(domain *domainData, login, otp string) (bool, error) {
+			return tt.valid, tt.err
+		}
+
+		if err := app.checkSecondFactor(&domainData{}, testLogin, "123456"); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
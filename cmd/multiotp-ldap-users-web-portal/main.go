@@ -1,94 +1,80 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
-	"database/sql"
-	_ "embed"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"html/template"
 	"log/slog"
 	"net/http"
 	"os"
+	"sync/atomic"
 	"time"
 
-	"github.com/alexedwards/scs/mysqlstore"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
 	"github.com/slayerjk/go-vafswork"
 
-	_ "github.com/go-sql-driver/mysql"
-
 	dataembed "github.com/slayerjk/go-multiotp-ldap-users-web-portal/data"
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/config"
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/jobs"
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/metrics"
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/multiotp"
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/otpauth"
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/qrwork"
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/ratelimit"
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/secrets"
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/sessionstore"
 )
 
 const appName = "OTP-Portal"
 
-type application struct {
-	logger          *slog.Logger
-	templateCache   map[string]*template.Template
-	formDecoder     *form.Decoder
-	sessionManager  *scs.SessionManager
-	multiOTPBinPath *string
-	// domain data
-	userDomainFQDN       string
-	userDomainBaseDN     string
-	qrDomainFQDN         string
-	qrDomainBaseDN       string
-	qrDomainBindUser     string
-	qrDomainBindUserPass string
-	mfaUrl               string
-	mfaTriggerUser       string
-	mfaTriggerUserPass   string
-	lang                 *string
-	secondFactorOn       *bool
-}
+// QR reissue jobs: parallel workers & max queued jobs
+const (
+	reissueWorkers   = 2
+	reissueQueueSize = 100
+)
+
+// exit codes
+const (
+	exitSessionStoreUnavailable = 3 // session db is unreachable after all retries
+)
 
-type AppData struct {
-	UserDomainFQDN       string `json:"userDomainFQDN"`
-	UserDomainBaseDN     string `json:"userDomainBaseDN"`
-	QrDomainFQDN         string `json:"qrDomainFQDN"`
-	QrDomainBaseDN       string `json:"qrDomainBaseDN"`
-	QrDomainBindUser     string `json:"qrDomainBindUser"`
-	QrDomainBindUserPass string `json:"qrDomainBindUserPass"`
-	DbUser               string `json:"dbUser"`
-	DbPass               string `json:"dbPass"`
-	MfaUrl               string `json:"mfaUrl"`
-	MfaTriggerUser       string `json:"mfaTriggerUser"`
-	MfaTriggerUserPass   string `json:"mfaTriggerUserPass"`
+type application struct {
+	logger         *slog.Logger
+	templateCache  map[string]*template.Template
+	formDecoder    *form.Decoder
+	sessionManager *scs.SessionManager
+	sessionStore   *sessionstore.Store
+	multiOTP       multiotp.Client
+	tokenProfiles  []multiotp.TokenProfile // chosen by user on reissue
+	tokenRewrite   *otpauth.Rewrite        // issuer, label & image of otpauth URL shown to user
+	// domain & MFA data, swapped on config reload
+	domain         atomic.Pointer[domainData]
+	readiness      readiness
+	reissueJobs    *jobs.Queue
+	otpTestLimiter *ratelimit.Limiter // "test my token" attempts per user
+	resyncLimiter  *ratelimit.Limiter // token resync attempts per user
+	unlockMax      int                // self-unlocks per user in 24h(kept in session db), 0 if disabled
+	passLimiter    *ratelimit.Limiter // password re-entries per user(self-unlock, secret key)
+	qrOptions      qrwork.Options     // QR rendering(svg & png)
+	qrPngSize      int                // default size(px) of QR png
+	qrReveal       time.Duration      // QR is shown after 'Show QR' click for this time
+	enrollmentMode bool               // QR is shown only until enrollment is confirmed
+	// password re-entry check(LDAP bind to user domain), replaced in tests
+	checkPassword  func(login, password string) error
+	lang           *string
+	secondFactorOn *bool
+	// LDAP reachability check of readiness probe, replaced in tests
+	checkLDAP func(ctx context.Context, fqdn string) error
+	// PrivacyIdea validate check of login OTP, replaced in tests
+	validateOTP func(domain *domainData, login, otp string) (bool, error)
 }
 
 func main() {
-	var (
-		workDir              string = vafswork.GetExePath()
-		logsPathDefault      string = workDir + "/logs" + "_" + appName
-		tlsCertDefault       string = workDir + "/tls" + "/" + "cert.pem"
-		tlsKeyDefault        string = workDir + "/tls" + "/" + "key.pem"
-		userDomainFQDN       string
-		userDomainBaseDN     string
-		qrDomainFQDN         string
-		qrDomainBaseDN       string
-		qrDomainBindUser     string
-		qrDomainBindUserPass string
-		dbUser               string
-		dbPass               string
-		appData              AppData
-		mfaUrl               string
-		mfaTriggerUser       string
-		mfaTriggerUserPass   string
-	)
-
-	// setting flags
-	logsDir := flag.String("log-dir", logsPathDefault, "set custom log dir")
-	logsToKeep := flag.Int("keep-logs", 30, "set number of logs to keep after rotation")
-	addr := flag.String("addr", ":3000", "HTTP server address, ex. ':3000' for localhost:3000")
-	tlsCert := flag.String("tls-cert", tlsCertDefault, "full path to tls Cert file")
-	tlsKey := flag.String("tls-key", tlsKeyDefault, "full path to tls Key file")
-	dbName := flag.String("db", "otpportal", "MySQL db name")
-	multiOTPBinPath := flag.String("m", "c:/MultiOTP/windows/multiotp.exe", "Full path to MulitOTP binary")
-	lang := flag.String("lang", "ru", "Set pages languages('ru'/'en' only)")
-	secondFactorOn := flag.Bool("2fa", false, "Use (PrivacyIdea API) provider for second factor auth")
+	// setting flags(all config flags are registered by config loader)
+	configLoader := config.NewLoader(flag.CommandLine, dataembed.DataFileBytes())
 
 	flag.Usage = func() {
 		fmt.Println("MultiOTP Web Portal for LDAP Users")
@@ -99,9 +85,16 @@ func main() {
 	}
 	flag.Parse()
 
+	// load & validate config: defaults -> config file(or embedded data file) -> env -> flags
+	cfg, err := configLoader.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stdout, "failed to load config:\n\t%v\n", err)
+		os.Exit(1)
+	}
+
 	// create logs dir
-	if err := os.MkdirAll(*logsDir, os.ModePerm); err != nil {
-		fmt.Fprintf(os.Stdout, "failed to create log dir %s:\n\t%v", *logsDir, err)
+	if err := os.MkdirAll(cfg.LogDir, os.ModePerm); err != nil {
+		fmt.Fprintf(os.Stdout, "failed to create log dir %s:\n\t%v", cfg.LogDir, err)
 		os.Exit(1)
 	}
 
@@ -109,7 +102,7 @@ func main() {
 	dateNow := time.Now().Format("02.01.2006")
 
 	// create log file
-	logFilePath := fmt.Sprintf("%s/%s_%s.log", *logsDir, appName, dateNow)
+	logFilePath := fmt.Sprintf("%s/%s_%s.log", cfg.LogDir, appName, dateNow)
 	// open logFile in Append mode
 	logFile, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 	if err != nil {
@@ -118,66 +111,69 @@ func main() {
 	}
 	defer logFile.Close()
 
-	// set slog.Logger
-	logger := slog.New(slog.NewTextHandler(logFile, nil))
+	// set slog.Logger, resolved secrets are hidden in every log line
+	logger := slog.New(secrets.NewRedactHandler(slog.NewTextHandler(logFile, nil), configLoader.Redactor()))
 
-	// processing data file
-	err = json.Unmarshal(dataembed.DataFileBytes, &appData)
-	if err != nil {
-		fmt.Printf("can't process data file:\n\t%v", err)
-		os.Exit(1)
+	// config source info
+	if configPath := configLoader.ConfigPath(); len(configPath) != 0 {
+		logger.Info("config loaded", "configPath", configPath)
+	} else {
+		logger.Info("config loaded", "configPath", "embedded data file/defaults")
 	}
 
-	userDomainFQDN = appData.UserDomainFQDN
-	userDomainBaseDN = appData.UserDomainBaseDN
-	qrDomainFQDN = appData.QrDomainFQDN
-	qrDomainBaseDN = appData.QrDomainBaseDN
-	qrDomainBindUser = appData.QrDomainBindUser
-	qrDomainBindUserPass = appData.QrDomainBindUserPass
-	dbUser = appData.DbUser
-	dbPass = appData.DbPass
-
-	// checking mfa vars
-	if *secondFactorOn {
-		mfaUrl = appData.MfaUrl
-		mfaTriggerUser = appData.MfaTriggerUser
-		mfaTriggerUserPass = appData.MfaTriggerUserPass
-
-		if len(mfaUrl) == 0 || len(mfaTriggerUser) == 0 || len(mfaTriggerUserPass) == 0 {
-			logger.Error("one or serveral mfa data not found in data file or empty! exiting")
-			os.Exit(1)
-		}
+	// open session store(with retries), db schema is created/migrated if needed
+	logRetry := func(attempt int, wait time.Duration, err error) {
+		logger.Warn("failed to open session store, retrying", "attempt", attempt, "wait", wait, slog.Any("error", err))
 	}
-
-	// check if multiOTPBinPath is exist
-	if _, err := os.Stat(*multiOTPBinPath); err != nil {
-		logger.Error("failed to find MultiOTP binary file", "multiOTPBinPath", *multiOTPBinPath)
-		os.Exit(1)
+	sessionStore, err := sessionstore.OpenRetry(sessionstore.Options{
+		Kind: cfg.SessionStore,
+		Host: cfg.DbHost,
+		Port: cfg.DbPort,
+		User: cfg.DbUser,
+		Pass: cfg.DbPass,
+		Name: cfg.DbName,
+		TLS:  cfg.DbTLS,
+		Path: cfg.DbPath,
+		// logs expired sessions cleanup errors
+		Logger: logger,
+	}, cfg.DbRetries, cfg.DbBackoff.Duration, cfg.DbMaxBackoff.Duration, logRetry)
+	if err != nil {
+		logger.Error("failed to open session store, exiting", "sessionStore", cfg.SessionStore, "retries", cfg.DbRetries, slog.Any("error", err))
+		fmt.Fprintf(os.Stdout, "failed to open session store:\n\t%v\n", err)
+		os.Exit(exitSessionStoreUnavailable)
 	}
+	defer sessionStore.Close()
 
-	// check if TLS cert exists
-	if _, err := os.Stat(*tlsCert); err != nil {
-		logger.Error("failed to find TLS cert file", "tlsCert", *tlsCert)
+	// MultiOTP client(local binary or remote agent),
+	// its capabilities are detected to choose reissue strategy
+	multiOTP, err := newMultiOTPClient(cfg, logger)
+	if err != nil {
+		logger.Error("failed to init MultiOTP client", slog.Any("error", err))
+		fmt.Fprintf(os.Stdout, "failed to init MultiOTP client:\n\t%v\n", err)
 		os.Exit(1)
 	}
 
-	// check if TLS key exists
-	if _, err := os.Stat(*tlsKey); err != nil {
-		logger.Error("failed to find TLS key file", "tlsCert", *tlsKey)
-		os.Exit(1)
+	// company logo in the middle of QR
+	var qrLogo *qrwork.Logo
+	if len(cfg.QRLogo) != 0 {
+		qrLogo, err = qrwork.LoadLogo(cfg.QRLogo)
+		if err != nil {
+			logger.Error("failed to load QR logo", "qrLogo", cfg.QRLogo, slog.Any("error", err))
+			fmt.Fprintf(os.Stdout, "failed to load QR logo:\n\t%v\n", err)
+			os.Exit(1)
+		}
 	}
 
-	// setting dsn using OTP_DB_USR, OTP_DB_PASS and dbName
-	dsn := fmt.Sprintf("%s:%s@/%s?parseTime=true", dbUser, dbPass, *dbName)
-	// try to open db
-	db, err := openDB(dsn)
+	// issuer, label & image of otpauth URLs shown to users
+	tokenRewrite, err := otpauth.NewRewrite(cfg.TokenIssuer, cfg.TokenLabel, cfg.TokenImage)
 	if err != nil {
-		logger.Error(err.Error())
+		logger.Error("failed to init otpauth rewrite", slog.Any("error", err))
+		fmt.Fprintf(os.Stdout, "failed to init otpauth rewrite:\n\t%v\n", err)
+		os.Exit(1)
 	}
-	defer db.Close()
 
 	// Initialize a new template cache...
-	templateCache, err := newTemplateCache(*lang)
+	templateCache, err := newTemplateCache(cfg.Lang)
 	if err != nil {
 		logger.Error(err.Error())
 		os.Exit(1)
@@ -185,8 +181,8 @@ func main() {
 
 	// Init session manager
 	sessionManager := scs.New()
-	sessionManager.Store = mysqlstore.New(db)
-	sessionManager.Lifetime = 30 * time.Minute
+	sessionManager.Store = sessionStore
+	sessionManager.Lifetime = cfg.SessionLifetime.Duration
 	sessionManager.Cookie.Secure = true
 
 	// Initialize a decoder instance...
@@ -194,35 +190,61 @@ func main() {
 
 	// define app
 	app := &application{
-		logger:               logger,
-		templateCache:        templateCache,
-		formDecoder:          formDecoder,
-		sessionManager:       sessionManager,
-		multiOTPBinPath:      multiOTPBinPath,
-		userDomainFQDN:       userDomainFQDN,
-		userDomainBaseDN:     userDomainBaseDN,
-		qrDomainFQDN:         qrDomainFQDN,
-		qrDomainBaseDN:       qrDomainBaseDN,
-		qrDomainBindUser:     qrDomainBindUser,
-		qrDomainBindUserPass: qrDomainBindUserPass,
-		mfaUrl:               mfaUrl,
-		mfaTriggerUser:       mfaTriggerUser,
-		mfaTriggerUserPass:   mfaTriggerUserPass,
-		secondFactorOn:       secondFactorOn,
-		lang:                 lang,
+		logger:         logger,
+		templateCache:  templateCache,
+		formDecoder:    formDecoder,
+		sessionManager: sessionManager,
+		sessionStore:   sessionStore,
+		multiOTP:       multiOTP,
+		tokenProfiles:  cfg.TokenProfiles,
+		tokenRewrite:   tokenRewrite,
+		otpTestLimiter: ratelimit.New(cfg.OTPTestMaxAttempts, cfg.OTPTestWindow.Duration),
+		resyncLimiter:  ratelimit.New(cfg.OTPTestMaxAttempts, cfg.OTPTestWindow.Duration),
+		unlockMax:      cfg.SelfUnlockMaxPerDay,
+		passLimiter:    ratelimit.New(cfg.PasswordMaxAttempts, cfg.PasswordWindow.Duration),
+		qrPngSize:      cfg.QRPngSize,
+		qrReveal:       cfg.QRRevealTimeout.Duration,
+		enrollmentMode: cfg.EnrollmentMode,
+		qrOptions:      cfg.QROptions(qrLogo),
+		secondFactorOn: &cfg.SecondFactorOn,
+		lang:           &cfg.Lang,
+	}
+	domain, err := newDomainData(cfg)
+	if err != nil {
+		logger.Error("failed to init PrivacyIdea client", slog.Any("error", err))
+		fmt.Fprintf(os.Stdout, "failed to init PrivacyIdea client:\n\t%v\n", err)
+		os.Exit(1)
+	}
+	app.domain.Store(domain)
+	app.checkPassword = app.checkUserPassword
+	app.checkLDAP = checkLDAPConn
+	app.validateOTP = mfaValidateOTP
+
+	// background QR reissue jobs
+	app.reissueJobs = jobs.NewQueue(reissueWorkers, reissueQueueSize, app.runReissueJob)
+
+	// session store errors(load/save in LoadAndSave) render "service unavailable" page
+	sessionManager.ErrorFunc = app.sessionStoreError
+
+	// load TLS cert/key, it may be reloaded later(see reloadConfig)
+	certs, err := newCertReloader(cfg.TLSCert, cfg.TLSKey)
+	if err != nil {
+		logger.Error("failed to load TLS cert/key", "tlsCert", cfg.TLSCert, "tlsKey", cfg.TLSKey, slog.Any("error", err))
+		os.Exit(1)
 	}
 
 	tlsConfig := &tls.Config{
 		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		GetCertificate:   certs.GetCertificate,
 	}
 
 	srv := &http.Server{
-		Addr:         *addr,
+		Addr:         cfg.Addr,
 		ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelError),
 		TLSConfig:    tlsConfig,
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 15 * time.Second,
+		IdleTimeout:  cfg.IdleTimeout.Duration,
+		ReadTimeout:  cfg.ReadTimeout.Duration,
+		WriteTimeout: cfg.WriteTimeout.Duration,
 		Handler:      app.routes(),
 	}
 
@@ -230,34 +252,71 @@ func main() {
 	logger.Info("Program started", "appName", appName)
 
 	// rotate log first
-	logger.Info("Log rotation first", "logsDir", *logsDir, "logs to keep", *logsToKeep)
-	if err := vafswork.RotateFilesByMtime(*logsDir, *logsToKeep); err != nil {
+	logger.Info("Log rotation first", "logsDir", cfg.LogDir, "logs to keep", cfg.KeepLogs)
+	if err := vafswork.RotateFilesByMtime(cfg.LogDir, cfg.KeepLogs); err != nil {
 		fmt.Fprintf(os.Stdout, "failed to rotate logs:\n\t%v", err)
 	}
 
-	// starting http srv info
-	logger.Info("starting server", slog.Any("addr", *addr))
+	// active sessions gauge
+	metrics.RegisterActiveSessions(func() (int, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return sessionStore.Count(ctx)
+	})
+
+	// admin listener(plain HTTP, keep it on localhost/internal network)
+	var adminSrv *http.Server
+	if len(cfg.AdminAddr) != 0 {
+		adminSrv = &http.Server{
+			Addr:         cfg.AdminAddr,
+			ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelError),
+			IdleTimeout:  cfg.IdleTimeout.Duration,
+			ReadTimeout:  cfg.ReadTimeout.Duration,
+			WriteTimeout: cfg.WriteTimeout.Duration,
+			Handler:      app.adminRoutes(),
+		}
+	}
 
-	// starting HTTP server
-	err = srv.ListenAndServeTLS(*tlsCert, *tlsKey)
-	logger.Error(err.Error())
-	os.Exit(1)
+	// reload config on SIGHUP(or config/TLS files change)
+	go app.watchConfig(configLoader, certs, cfg.ConfigWatchInterval.Duration)
+
+	// serve till SIGINT/SIGTERM, then shutdown gracefully
+	err = app.serve(srv, adminSrv, cfg.ShutdownTimeout.Duration)
+	if err != nil {
+		logger.Error(err.Error())
+		sessionStore.Close()
+		logFile.Sync()
+		os.Exit(1)
+	}
 
+	logger.Info("Program stopped", "appName", appName)
+	// deferred: session store(db pool) & log file are closed
+	logFile.Sync()
 }
 
-// The openDB() function wraps sql.Open() and returns a sql.DB connection pool
-// for a given DSN.
-func openDB(dsn string) (*sql.DB, error) {
-	db, err := sql.Open("mysql", dsn)
-	if err != nil {
-		return nil, err
+// Make MultiOTP client for configured backend.
+// Commands are killed after timeouts, 'auto' reissue strategy
+// is resolved by MultiOTP capabilities.
+func newMultiOTPClient(cfg *config.Config, logger *slog.Logger) (multiotp.Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	opts := multiotp.Options{
+		Strategy:    cfg.ReissueStrategy,
+		Timeout:     cfg.MultiOTPTimeout.Duration,
+		SyncTimeout: cfg.MultiOTPSyncTimeout.Duration,
+		Profiles:    len(cfg.TokenProfiles) != 0,
+		PHPPath:     cfg.MultiOTPPHPPath,
+		Logger:      logger.With("multiOTPBackend", cfg.MultiOTPBackend),
 	}
 
-	err = db.Ping()
-	if err != nil {
-		db.Close()
-		return nil, err
+	if cfg.MultiOTPBackend == multiotp.BackendHTTP {
+		return multiotp.NewHTTPClient(ctx, multiotp.HTTPConfig{
+			URL:    cfg.MultiOTPAgentURL,
+			Token:  cfg.MultiOTPAgentToken,
+			CAFile: cfg.MultiOTPAgentCAFile,
+		}, opts)
 	}
 
-	return db, nil
+	return multiotp.NewCLI(ctx, cfg.MultiOTPBinPath, opts)
 }
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/config"
+)
+
+// Domain & MFA data, may be changed on config reload.
+// It's swapped as a whole(app.domain.Store), so handlers must Load() it once
+// per request and use the same copy till the end.
+type domainData struct {
+	userDomainFQDN       string
+	userDomainBaseDN     string
+	qrDomainFQDN         string
+	qrDomainBaseDN       string
+	qrDomainBindUser     string
+	qrDomainBindUserPass string
+	mfaUrl               string
+	mfaTriggerUser       string
+	mfaTriggerUserPass   string
+	mfaClient            *http.Client // PrivacyIdea client(TLS by mfaCAFile)
+}
+
+// Make domainData from config, error means PrivacyIdea CA file is invalid
+func newDomainData(cfg *config.Config) (*domainData, error) {
+	mfaClient, err := newMfaHTTPClient(cfg.MfaCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &domainData{
+		userDomainFQDN:       cfg.UserDomainFQDN,
+		userDomainBaseDN:     cfg.UserDomainBaseDN,
+		qrDomainFQDN:         cfg.QrDomainFQDN,
+		qrDomainBaseDN:       cfg.QrDomainBaseDN,
+		qrDomainBindUser:     cfg.QrDomainBindUser,
+		qrDomainBindUserPass: cfg.QrDomainBindUserPass,
+		mfaUrl:               cfg.MfaUrl,
+		mfaTriggerUser:       cfg.MfaTriggerUser,
+		mfaTriggerUserPass:   cfg.MfaTriggerUserPass,
+		mfaClient:            mfaClient,
+	}, nil
+}
+
+// certReloader holds current TLS cert/key pair,
+// used as tls.Config.GetCertificate
+type certReloader struct {
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	certPath string
+	keyPath  string
+}
+
+// Load cert/key pair first time
+func newCertReloader(certPath, keyPath string) (*certReloader, error) {
+	c := &certReloader{}
+	if err := c.reload(certPath, keyPath); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Load new cert/key pair, on error current pair stays active
+func (c *certReloader) reload(certPath, keyPath string) error {
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.cert = &cert
+	c.certPath = certPath
+	c.keyPath = keyPath
+	c.mu.Unlock()
+
+	return nil
+}
+
+// files to watch for changes
+func (c *certReloader) paths() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return []string{c.certPath, c.keyPath}
+}
+
+// GetCertificate is used by tls.Config
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.cert, nil
+}
+
+// Reload config: parse & validate, then swap domain data and TLS cert.
+// Invalid config is rejected and logged, old one stays active.
+// Only domain, MFA data and TLS cert/key are applied,
+// other changed values need restart.
+func (app *application) reloadConfig(loader *config.Loader, certs *certReloader) {
+	app.logger.Info("reloading config", "configPath", loader.ConfigPath())
+
+	cfg, err := loader.Load()
+	if err != nil {
+		app.logger.Error("config reload rejected, keeping current config", slog.Any("error", err))
+		return
+	}
+
+	domain, err := newDomainData(cfg)
+	if err != nil {
+		app.logger.Error("config reload rejected, keeping current config", slog.Any("error", err))
+		return
+	}
+	app.domain.Store(domain)
+	app.logger.Info("domain & MFA data reloaded")
+
+	if err := certs.reload(cfg.TLSCert, cfg.TLSKey); err != nil {
+		app.logger.Error("TLS cert reload rejected, keeping current cert", "tlsCert", cfg.TLSCert, "tlsKey", cfg.TLSKey, slog.Any("error", err))
+		return
+	}
+	app.logger.Info("TLS cert reloaded", "tlsCert", cfg.TLSCert, "tlsKey", cfg.TLSKey)
+}
+
+// Reload config on SIGHUP and(if interval > 0) on config or TLS files' change.
+// Runs until the program ends.
+func (app *application) watchConfig(loader *config.Loader, certs *certReloader, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	// file watching is mtime polling, it works the same on Windows & Linux
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	watched := func() []string {
+		files := certs.paths()
+		if path := loader.ConfigPath(); len(path) != 0 {
+			files = append(files, path)
+		}
+		return files
+	}
+	lastMod := modTimes(watched())
+
+	for {
+		select {
+		case <-hup:
+			app.logger.Info("got SIGHUP")
+		case <-tick:
+			current := modTimes(watched())
+			if equalModTimes(lastMod, current) {
+				continue
+			}
+			app.logger.Info("config or TLS files changed")
+		}
+
+		app.reloadConfig(loader, certs)
+		lastMod = modTimes(watched())
+	}
+}
+
+// get files' mtime, missing files are skipped
+func modTimes(files []string) map[string]time.Time {
+	result := make(map[string]time.Time, len(files))
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			result[file] = info.ModTime()
+		}
+	}
+
+	return result
+}
+
+// compare two results of modTimes
+func equalModTimes(a, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for file, mod := range a {
+		if !b[file].Equal(mod) {
+			return false
+		}
+	}
+
+	return true
+}
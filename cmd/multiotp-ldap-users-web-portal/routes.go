@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/justinas/alice"
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/metrics"
 	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/ui"
 )
 
@@ -13,6 +14,10 @@ func (app *application) routes() http.Handler {
 
 	mux.Handle("GET /static/", http.FileServerFS(ui.Files))
 
+	// health & readiness probes(for all, no session & CSRF)
+	mux.HandleFunc("GET /healthz", app.healthz)
+	mux.HandleFunc("GET /readyz", app.readyz)
+
 	// for dynamic pages, see all
 	dynamic := alice.New(app.sessionManager.LoadAndSave, noSurf, app.authenticate)
 
@@ -29,14 +34,47 @@ func (app *application) routes() http.Handler {
 	// logout (for authenticated user)
 	mux.Handle("POST /user/logout", protected.ThenFunc(app.userLogoutPost))
 
-	// view QR (for authenticated user)
+	// view QR, POST reveals QR for qrRevealTimeout (for authenticated user)
 	mux.Handle("GET /qr/view", protected.ThenFunc(app.qrView))
+	mux.Handle("POST /qr/view", protected.ThenFunc(app.qrRevealPost))
+
+	// reissue QR job & its status (for authenticated user)
+	mux.Handle("POST /qr/reissue", protected.ThenFunc(app.qrReissuePost))
+	mux.Handle("GET /qr/reissue/{id}", protected.ThenFunc(app.qrReissueStatus))
+
+	// resync token with two consecutive OTPs (for authenticated user)
+	mux.Handle("GET /qr/resync", protected.ThenFunc(app.qrResync))
+	mux.Handle("POST /qr/resync", protected.ThenFunc(app.qrResyncPost))
+
+	// "test my token": check OTP (for authenticated user, rate limited)
+	mux.Handle("GET /qr/test", protected.ThenFunc(app.qrTest))
+	mux.Handle("POST /qr/test", protected.ThenFunc(app.qrTestPost))
+
+	// unlock locked token after password re-entry (for authenticated user, capped per day)
+	mux.Handle("GET /qr/unlock", protected.ThenFunc(app.qrUnlock))
+	mux.Handle("POST /qr/unlock", protected.ThenFunc(app.qrUnlockPost))
+
+	// QR png(download), print page & secret reveal after password re-entry (for authenticated user)
+	mux.Handle("GET /qr/image.png", protected.ThenFunc(app.qrImage))
+	mux.Handle("GET /qr/print", protected.ThenFunc(app.qrPrint))
+	mux.Handle("GET /qr/secret", protected.ThenFunc(app.qrSecret))
+	mux.Handle("POST /qr/secret", protected.ThenFunc(app.qrSecretPost))
 
-	// reissue QR (for authenticated user)
-	mux.Handle("GET /qr/reissue", protected.ThenFunc(app.qrReissue))
+	// enrollment confirmation with OTP, QR isn't shown after it (for authenticated user, enrollmentMode only)
+	mux.Handle("GET /qr/enroll", protected.ThenFunc(app.qrEnroll))
+	mux.Handle("POST /qr/enroll", protected.ThenFunc(app.qrEnrollPost))
 
 	// for all pages
-	standard := alice.New(app.recoverPanic, app.logRequest, commonHeaders)
+	standard := alice.New(metricsMiddleware, app.recoverPanic, app.logRequest, commonHeaders)
 
 	return standard.Then(mux)
 }
+
+// The adminRoutes() method returns a servemux for admin listener(metrics)
+func (app *application) adminRoutes() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.Handle("GET /metrics", metrics.Handler())
+
+	return app.recoverPanic(mux)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/multiotp"
+)
+
+// Run HTTPS server(and admin server if not nil) till SIGINT/SIGTERM.
+// On signal: stop accepting connections, wait for in-flight requests and
+// MultiOTP operations(ex. reissue between '-delete' and '-ldap-users-sync')
+// no longer than timeout.
+// Returns nil on clean shutdown.
+func (app *application) serve(srv, adminSrv *http.Server, timeout time.Duration) error {
+	serverErr := make(chan error, 2)
+
+	// starting http srv info
+	app.logger.Info("starting server", slog.Any("addr", srv.Addr))
+
+	// starting HTTP server(cert & key are taken from tlsConfig.GetCertificate)
+	go func() {
+		serverErr <- srv.ListenAndServeTLS("", "")
+	}()
+
+	if adminSrv != nil {
+		app.logger.Info("starting admin server", slog.Any("adminAddr", adminSrv.Addr))
+		go func() {
+			if err := adminSrv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				serverErr <- fmt.Errorf("admin server: %w", err)
+			}
+		}()
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-serverErr:
+		return err
+	case sig := <-stop:
+		app.logger.Info("shutting down", "signal", sig.String(), "timeout", timeout)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Shutdown closes listeners first, then waits for active requests
+	var errs []error
+	if err := srv.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("server shutdown: %w", err))
+	}
+	if adminSrv != nil {
+		if err := adminSrv.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("admin server shutdown: %w", err))
+		}
+	}
+
+	// reissue jobs: running ones are finished, queued ones are failed
+	if err := app.reissueJobs.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("reissue jobs aren't finished: %w", err))
+	}
+
+	// MultiOTP operations may outlive their requests
+	if err := multiotp.WaitOperations(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("in-flight MultiOTP operations aren't finished: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
@@ -4,8 +4,12 @@ import (
 	"html/template"
 	"io/fs"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/jobs"
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/multiotp"
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/otpauth"
 	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/ui"
 )
 
@@ -19,6 +23,54 @@ type templateData struct {
 	QR              template.HTML // must be <svg> code chunc to insert in template
 	Username        string
 	SecondFactorOn  bool
+	ReissueJob      *jobs.Job  // active QR reissue job, nil if none
+	Token           *tokenInfo // token metadata shown with QR, nil if none
+	TokenProfiles   []string   // token profile names to choose on reissue
+	TokenFound      bool       // user's token exists(QR may be hidden)
+	QRRevealSeconds int        // QR is hidden after this time on page
+	EnrollmentMode  bool       // QR is shown only until enrollment is confirmed
+	Enrolled        bool       // user has confirmed enrollment, QR isn't shown
+	QRPrintSize     int        // size(px) of QR png on print page
+	Secret          string     // base32 secret grouped by 4 chars, revealed after password re-entry
+}
+
+// Token metadata: parsed from otpauth URL & MultiOTP user info
+type tokenInfo struct {
+	Type      string // totp/hotp
+	Issuer    string
+	Account   string
+	Algorithm string
+	Digits    int
+	Period    int       // seconds, 0 for HOTP
+	Created   time.Time // creation/last reissue, zero if unknown
+	// lock & sync status, StatusKnown is false if user info isn't available
+	StatusKnown bool
+	Locked      bool
+	OutOfSync   bool
+}
+
+// Make tokenInfo of otpauth key, info may be nil(user info failed)
+func newTokenInfo(key *otpauth.Key, info multiotp.UserInfo) *tokenInfo {
+	token := &tokenInfo{
+		Type:      strings.ToUpper(key.Type),
+		Issuer:    key.Issuer,
+		Account:   key.Account,
+		Algorithm: key.Algorithm,
+		Digits:    key.Digits,
+		Period:    key.Period,
+	}
+	if info == nil {
+		return token
+	}
+
+	token.Created, _ = info.Created()
+	locked, lockedOK := info.Locked()
+	outOfSync, outOfSyncOK := info.OutOfSync()
+	token.StatusKnown = lockedOK || outOfSyncOK
+	token.Locked = locked
+	token.OutOfSync = outOfSync
+
+	return token
 }
 
 // Create a humanDate function which returns a human date
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"html"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/go-playground/form/v4"
+
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/jobs"
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/multiotp"
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/otpauth"
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/qrwork"
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/ratelimit"
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/sessionstore"
+)
+
+// test user: login, MultiOTP account & password accepted by checkPassword
+const (
+	testLogin    = "jsmith"
+	testAcc      = "jsmith"
+	testPassword = "pa$$word"
+)
+
+var errWrongPassword = errors.New("LDAP Result Code 49 \"Invalid Credentials\"")
+
+// Create application with MultiOTP fake & memory session store,
+// password check accepts testPassword only(no LDAP), LDAP readiness check passes
+func newTestApplication(t *testing.T, multiOTP *multiotp.Fake) *application {
+	t.Helper()
+
+	templateCache, err := newTemplateCache("en")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessionStore, err := sessionstore.Open(sessionstore.Options{Kind: sessionstore.Memory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// not closed: scs memstore's StopCleanup races with start of its
+	// cleanup goroutine(go test -race), the goroutine ends with test binary
+
+	sessionManager := scs.New()
+	sessionManager.Store = sessionStore
+	sessionManager.Lifetime = time.Hour
+	sessionManager.Cookie.Secure = true
+
+	tokenRewrite, err := otpauth.NewRewrite("", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lang, secondFactorOn := "en", false
+	app := &application{
+		logger:         slog.New(slog.DiscardHandler),
+		templateCache:  templateCache,
+		formDecoder:    form.NewDecoder(),
+		sessionManager: sessionManager,
+		sessionStore:   sessionStore,
+		multiOTP:       multiOTP,
+		tokenRewrite:   tokenRewrite,
+		otpTestLimiter: ratelimit.New(3, time.Hour),
+		resyncLimiter:  ratelimit.New(3, time.Hour),
+		unlockMax:      2,
+		passLimiter:    ratelimit.New(5, time.Hour),
+		qrOptions:      qrwork.DefaultOptions(),
+		qrPngSize:      300,
+		qrReveal:       time.Minute,
+		lang:           &lang,
+		secondFactorOn: &secondFactorOn,
+	}
+	app.domain.Store(&domainData{})
+	app.checkPassword = func(login, password string) error {
+		if password != testPassword {
+			return errWrongPassword
+		}
+		return nil
+	}
+	// LDAP of readiness probe is always reachable
+	app.checkLDAP = func(ctx context.Context, fqdn string) error {
+		return nil
+	}
+
+	app.reissueJobs = jobs.NewQueue(1, 10, app.runReissueJob)
+	t.Cleanup(func() { app.reissueJobs.Shutdown(context.Background()) })
+
+	return app
+}
+
+type testServer struct {
+	*httptest.Server
+}
+
+// Start TLS test server with app routes and cookie jar.
+// GET /test/login authenticates the session the way userLoginPost & qrView do
+// (LDAP isn't available in tests), GET /test/reveal starts QR reveal time
+// the way "Show QR" does.
+func newTestServer(t *testing.T, app *application) *testServer {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.Handle("/", app.routes())
+	mux.Handle("GET /test/login", app.sessionManager.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.sessionManager.Put(r.Context(), "authenticatedUserID", 1)
+		app.sessionManager.Put(r.Context(), "accName", testLogin)
+		app.sessionManager.Put(r.Context(), "QrAcc", testAcc)
+	})))
+	mux.Handle("GET /test/reveal", app.sessionManager.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.sessionManager.Put(r.Context(), "qrRevealedUntil", time.Now().Add(app.qrReveal).Unix())
+	})))
+
+	ts := httptest.NewTLSServer(mux)
+	t.Cleanup(ts.Close)
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.Client().Jar = jar
+
+	// don't follow redirects: return the first response
+	ts.Client().CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	return &testServer{ts}
+}
+
+// Authenticate test server's session
+func (ts *testServer) login(t *testing.T) {
+	t.Helper()
+
+	code, _, _ := ts.get(t, "/test/login")
+	if code != http.StatusOK {
+		t.Fatalf("test login: status %d", code)
+	}
+}
+
+// Start QR reveal time of test server's session
+func (ts *testServer) reveal(t *testing.T) {
+	t.Helper()
+
+	code, _, _ := ts.get(t, "/test/reveal")
+	if code != http.StatusOK {
+		t.Fatalf("test reveal: status %d", code)
+	}
+}
+
+// GET urlPath, returns status code, headers & body
+func (ts *testServer) get(t *testing.T, urlPath string) (int, http.Header, string) {
+	t.Helper()
+
+	rs, err := ts.Client().Get(ts.URL + urlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Body.Close()
+
+	body, err := io.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rs.StatusCode, rs.Header, string(bytes.TrimSpace(body))
+}
+
+// POST form to urlPath with CSRF token of page csrfPath
+func (ts *testServer) postForm(t *testing.T, urlPath, csrfPath string, form url.Values) (int, http.Header, string) {
+	t.Helper()
+
+	_, _, page := ts.get(t, csrfPath)
+	form.Set("csrf_token", extractCSRFToken(t, page))
+
+	rs, err := ts.Client().PostForm(ts.URL+urlPath, form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Body.Close()
+
+	body, err := io.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rs.StatusCode, rs.Header, string(bytes.TrimSpace(body))
+}
+
+// Flash message shown on the next page
+func (ts *testServer) flash(t *testing.T, urlPath string) string {
+	t.Helper()
+
+	_, _, body := ts.get(t, urlPath)
+	matches := flashRX.FindStringSubmatch(body)
+	if len(matches) < 2 {
+		return ""
+	}
+	return html.UnescapeString(matches[1])
+}
+
+var (
+	csrfTokenRX = regexp.MustCompile(`<input type='hidden' name='csrf_token' value='(.+)'>`)
+	flashRX     = regexp.MustCompile(`<div class='flash'>(.+)</div>`)
+)
+
+func extractCSRFToken(t *testing.T, body string) string {
+	t.Helper()
+
+	matches := csrfTokenRX.FindStringSubmatch(body)
+	if len(matches) < 2 {
+		t.Fatal("no csrf token found in body")
+	}
+
+	return html.UnescapeString(matches[1])
+}
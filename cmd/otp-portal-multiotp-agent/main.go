@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/multiotp"
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/secrets"
+)
+
+// MultiOTP agent: runs on MultiOTP host and serves MultiOTP operations
+// to OTP-Portal(multiOTPBackend 'http') over HTTPS, so the portal may run on other host.
+// Token is taken from OTP_PORTAL_MULTIOTP_AGENT_TOKEN env(not a flag:
+// flags are visible in process list).
+func main() {
+	addr := flag.String("addr", ":8443", "HTTPS server address")
+	binPath := flag.String("m", multiotp.DefaultBinPath, "Full path to MulitOTP binary(multiotp.exe, multiotp.php or wrapper script)")
+	phpPath := flag.String("php", multiotp.DefaultPHPPath, "PHP interpreter to run multiotp.php(used if MultiOTP binary is *.php)")
+	tlsCert := flag.String("tls-cert", "", "full path to tls Cert file")
+	tlsKey := flag.String("tls-key", "", "full path to tls Key file")
+	timeout := flag.Duration("timeout", 10*time.Second, "max duration of MultiOTP single user commands(-urllink, -delete, etc.)")
+	syncTimeout := flag.Duration("sync-timeout", 10*time.Minute, "max duration of MultiOTP LDAP users sync(-ldap-users-sync)")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 10*time.Minute, "max wait for in-flight requests and MultiOTP operations on shutdown")
+	debug := flag.Bool("debug", false, "log MultiOTP commands' output(secrets redacted)")
+
+	flag.Usage = func() {
+		fmt.Println("OTP-Portal MultiOTP agent")
+		fmt.Println("Token must be set in OTP_PORTAL_MULTIOTP_AGENT_TOKEN env")
+		fmt.Println("Flags:")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	token := os.Getenv("OTP_PORTAL_MULTIOTP_AGENT_TOKEN")
+	if len(token) == 0 {
+		fmt.Println("OTP_PORTAL_MULTIOTP_AGENT_TOKEN env is not set")
+		os.Exit(1)
+	}
+	if len(*tlsCert) == 0 || len(*tlsKey) == 0 {
+		fmt.Println("-tls-cert and -tls-key are required")
+		os.Exit(1)
+	}
+
+	// logs to stdout, token is hidden
+	level := slog.LevelInfo
+	if *debug {
+		level = slog.LevelDebug
+	}
+	redactor := secrets.NewRedactor()
+	redactor.Add(token)
+	logger := slog.New(secrets.NewRedactHandler(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: level}), redactor))
+
+	// strategy is chosen by portal, agent serves single operations only
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	client, err := multiotp.NewCLI(ctx, *binPath, multiotp.Options{
+		Strategy:    multiotp.StrategyAuto,
+		Timeout:     *timeout,
+		SyncTimeout: *syncTimeout,
+		PHPPath:     *phpPath,
+		Logger:      logger,
+	})
+	cancel()
+	if err != nil {
+		logger.Error("failed to init MultiOTP client", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	srv := &http.Server{
+		Addr:     *addr,
+		Handler:  multiotp.NewAgentHandler(client, token, logger),
+		ErrorLog: slog.NewLogLogger(logger.Handler(), slog.LevelError),
+		TLSConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		},
+		ReadTimeout: 10 * time.Second,
+		// sync may take long
+		WriteTimeout: *syncTimeout + time.Minute,
+		IdleTimeout:  time.Minute,
+	}
+
+	// graceful shutdown: wait for in-flight requests & MultiOTP operations
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	errCh := make(chan error, 1)
+	go func() {
+		logger.Info("MultiOTP agent started", "addr", *addr, "multiOTPBinPath", *binPath)
+		errCh <- srv.ListenAndServeTLS(*tlsCert, *tlsKey)
+	}()
+
+	select {
+	case err := <-errCh:
+		logger.Error("MultiOTP agent failed", slog.Any("error", err))
+		os.Exit(1)
+	case sig := <-stop:
+		logger.Info("shutting down", "signal", sig.String())
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+
+	err = errors.Join(srv.Shutdown(ctx), multiotp.WaitOperations(ctx))
+	if err != nil {
+		logger.Error("shutdown timeout exceeded", slog.Any("error", err))
+		os.Exit(1)
+	}
+	logger.Info("MultiOTP agent stopped")
+}
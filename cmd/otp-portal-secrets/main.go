@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/secrets"
+)
+
+// Tool to make/read local encrypted secrets file of OTP-Portal.
+// Master key is taken from OTP_PORTAL_SECRETS_MASTER_KEY env(not a flag:
+// flags are visible in process list).
+func main() {
+	in := flag.String("in", "", "plain JSON file to encrypt, ex. {\"qrBindPass\": \"secret\"}")
+	out := flag.String("out", "", "encrypted secrets file to write")
+	list := flag.String("list", "", "encrypted secrets file to list secret names of")
+
+	flag.Usage = func() {
+		fmt.Println("OTP-Portal secrets file tool")
+		fmt.Println("Master key must be set in OTP_PORTAL_SECRETS_MASTER_KEY env")
+		fmt.Println("Flags:")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	masterKey := os.Getenv("OTP_PORTAL_SECRETS_MASTER_KEY")
+	if len(masterKey) == 0 {
+		fmt.Println("OTP_PORTAL_SECRETS_MASTER_KEY env is not set")
+		os.Exit(1)
+	}
+
+	switch {
+	case len(*list) != 0:
+		store, err := secrets.ReadStore(*list, masterKey)
+		if err != nil {
+			fmt.Printf("failed to read secrets file:\n\t%v\n", err)
+			os.Exit(1)
+		}
+		for name := range store {
+			fmt.Println(name)
+		}
+
+	case len(*in) != 0 && len(*out) != 0:
+		data, err := os.ReadFile(*in)
+		if err != nil {
+			fmt.Printf("failed to read plain file:\n\t%v\n", err)
+			os.Exit(1)
+		}
+
+		store := make(map[string]string)
+		if err := json.Unmarshal(data, &store); err != nil {
+			fmt.Printf("plain file must be JSON object of strings:\n\t%v\n", err)
+			os.Exit(1)
+		}
+
+		if err := secrets.WriteStore(*out, masterKey, store); err != nil {
+			fmt.Printf("failed to write secrets file:\n\t%v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("%d secrets written to %s, now delete %s\n", len(store), *out, *in)
+
+	default:
+		flag.Usage()
+		os.Exit(1)
+	}
+}
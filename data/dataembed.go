@@ -1,6 +1,19 @@
 package dataembed
 
-import _ "embed"
+import "embed"
 
-//go:embed data.json
-var DataFileBytes []byte
+// data.json is optional now: it is used only as fallback config
+// when no config file is given(see internal/config)
+//
+//go:embed *.json
+var files embed.FS
+
+// Return embedded data.json content, nil if it wasn't present at build time
+func DataFileBytes() []byte {
+	data, err := files.ReadFile("data.json")
+	if err != nil {
+		return nil
+	}
+
+	return data
+}
@@ -0,0 +1,652 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/multiotp"
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/otpauth"
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/qrwork"
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/secrets"
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/sessionstore"
+	"github.com/slayerjk/go-vafswork"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+Config values are layered in this order(later wins):
+1) built-in defaults(see Default())
+2) config file given by -config flag or OTP_PORTAL_CONFIG env
+   (JSON/YAML/TOML, chosen by file extension);
+   embedded data/data.json is used only if no config file is given
+3) OTP_PORTAL_* environment variables
+4) command line flags(only those explicitly set)
+*/
+
+// env var to set config file path, when -config flag is not used
+const configPathEnv = "OTP_PORTAL_CONFIG"
+
+// Config holds all portal settings.
+// Field tags:
+//   - json/yaml/toml - key in config file
+//   - env - environment variable name
+//   - flag - command line flag name(no flag for secrets)
+//   - usage - flag's help text
+//   - secret - value may be a secret reference(see internal/secrets),
+//     resolved values are hidden in logs
+type Config struct {
+	// server
+	Addr            string   `json:"addr" yaml:"addr" toml:"addr" env:"OTP_PORTAL_ADDR" flag:"addr" usage:"HTTP server address, ex. ':3000' for localhost:3000"`
+	TLSCert         string   `json:"tlsCert" yaml:"tlsCert" toml:"tlsCert" env:"OTP_PORTAL_TLS_CERT" flag:"tls-cert" usage:"full path to tls Cert file"`
+	TLSKey          string   `json:"tlsKey" yaml:"tlsKey" toml:"tlsKey" env:"OTP_PORTAL_TLS_KEY" flag:"tls-key" usage:"full path to tls Key file"`
+	IdleTimeout     Duration `json:"idleTimeout" yaml:"idleTimeout" toml:"idleTimeout" env:"OTP_PORTAL_IDLE_TIMEOUT" flag:"idle-timeout" usage:"HTTP server idle timeout"`
+	ReadTimeout     Duration `json:"readTimeout" yaml:"readTimeout" toml:"readTimeout" env:"OTP_PORTAL_READ_TIMEOUT" flag:"read-timeout" usage:"HTTP server read timeout"`
+	WriteTimeout    Duration `json:"writeTimeout" yaml:"writeTimeout" toml:"writeTimeout" env:"OTP_PORTAL_WRITE_TIMEOUT" flag:"write-timeout" usage:"HTTP server write timeout"`
+	AdminAddr       string   `json:"adminAddr" yaml:"adminAddr" toml:"adminAddr" env:"OTP_PORTAL_ADMIN_ADDR" flag:"admin-addr" usage:"admin HTTP server address(/metrics), empty - disabled"`
+	ShutdownTimeout Duration `json:"shutdownTimeout" yaml:"shutdownTimeout" toml:"shutdownTimeout" env:"OTP_PORTAL_SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" usage:"max wait for in-flight requests and MultiOTP operations on shutdown"`
+	SessionLifetime Duration `json:"sessionLifetime" yaml:"sessionLifetime" toml:"sessionLifetime" env:"OTP_PORTAL_SESSION_LIFETIME" flag:"session-lifetime" usage:"user's session lifetime"`
+
+	// reload config on file change(polling interval), 0 - only on SIGHUP
+	ConfigWatchInterval Duration `json:"configWatchInterval" yaml:"configWatchInterval" toml:"configWatchInterval" env:"OTP_PORTAL_CONFIG_WATCH_INTERVAL" flag:"config-watch-interval" usage:"reload config on config/TLS files change, check interval(0 - reload on SIGHUP only)"`
+
+	// logs
+	LogDir   string `json:"logDir" yaml:"logDir" toml:"logDir" env:"OTP_PORTAL_LOG_DIR" flag:"log-dir" usage:"set custom log dir"`
+	KeepLogs int    `json:"keepLogs" yaml:"keepLogs" toml:"keepLogs" env:"OTP_PORTAL_KEEP_LOGS" flag:"keep-logs" usage:"set number of logs to keep after rotation"`
+
+	// pages
+	Lang string `json:"lang" yaml:"lang" toml:"lang" env:"OTP_PORTAL_LANG" flag:"lang" usage:"Set pages languages('ru'/'en' only)"`
+
+	// MultiOTP: local binary or agent on remote host
+	MultiOTPBackend     string `json:"multiOTPBackend" yaml:"multiOTPBackend" toml:"multiOTPBackend" env:"OTP_PORTAL_MULTIOTP_BACKEND" flag:"multiotp-backend" usage:"MultiOTP backend: 'cli'(local binary) or 'http'(MultiOTP agent)"`
+	MultiOTPAgentURL    string `json:"multiOTPAgentURL" yaml:"multiOTPAgentURL" toml:"multiOTPAgentURL" env:"OTP_PORTAL_MULTIOTP_AGENT_URL" flag:"multiotp-agent-url" usage:"MultiOTP agent base URL(http backend)"`
+	MultiOTPAgentToken  string `json:"multiOTPAgentToken" yaml:"multiOTPAgentToken" toml:"multiOTPAgentToken" env:"OTP_PORTAL_MULTIOTP_AGENT_TOKEN" secret:"true"`
+	MultiOTPAgentCAFile string `json:"multiOTPAgentCAFile" yaml:"multiOTPAgentCAFile" toml:"multiOTPAgentCAFile" env:"OTP_PORTAL_MULTIOTP_AGENT_CA_FILE" flag:"multiotp-agent-ca-file" usage:"CA cert(PEM) of MultiOTP agent TLS cert, empty - system CAs(http backend)"`
+	MultiOTPBinPath     string `json:"multiOTPBinPath" yaml:"multiOTPBinPath" toml:"multiOTPBinPath" env:"OTP_PORTAL_MULTIOTP_BIN" flag:"m" usage:"Full path to MulitOTP binary(multiotp.exe, multiotp.php or wrapper script)"`
+	MultiOTPPHPPath     string `json:"multiOTPPHPPath" yaml:"multiOTPPHPPath" toml:"multiOTPPHPPath" env:"OTP_PORTAL_MULTIOTP_PHP" flag:"multiotp-php" usage:"PHP interpreter to run multiotp.php(used if MultiOTP binary is *.php)"`
+	ReissueStrategy     string `json:"reissueStrategy" yaml:"reissueStrategy" toml:"reissueStrategy" env:"OTP_PORTAL_REISSUE_STRATEGY" flag:"reissue-strategy" usage:"QR reissue strategy: 'auto', 'full-sync' or 'recreate'"`
+	// MultiOTP commands are killed after timeout
+	MultiOTPTimeout     Duration `json:"multiOTPTimeout" yaml:"multiOTPTimeout" toml:"multiOTPTimeout" env:"OTP_PORTAL_MULTIOTP_TIMEOUT" flag:"multiotp-timeout" usage:"max duration of MultiOTP single user commands(-urllink, -delete, etc.)"`
+	MultiOTPSyncTimeout Duration `json:"multiOTPSyncTimeout" yaml:"multiOTPSyncTimeout" toml:"multiOTPSyncTimeout" env:"OTP_PORTAL_MULTIOTP_SYNC_TIMEOUT" flag:"multiotp-sync-timeout" usage:"max duration of MultiOTP LDAP users sync(-ldap-users-sync)"`
+	// token profiles users may choose on reissue(config file only), empty - MultiOTP default token only
+	TokenProfiles []multiotp.TokenProfile `json:"tokenProfiles" yaml:"tokenProfiles" toml:"tokenProfiles"`
+	// issuer, label & image of otpauth URL shown to users(QR, secret key), empty - as returned by MultiOTP
+	TokenIssuer string `json:"tokenIssuer" yaml:"tokenIssuer" toml:"tokenIssuer" env:"OTP_PORTAL_TOKEN_ISSUER" flag:"token-issuer" usage:"issuer of token shown in authenticator apps, empty - MultiOTP's one"`
+	TokenLabel  string `json:"tokenLabel" yaml:"tokenLabel" toml:"tokenLabel" env:"OTP_PORTAL_TOKEN_LABEL" flag:"token-label" usage:"label template of token(ex. '{{.Company}}:{{.sAMAccountName}}'), empty - MultiOTP's one"`
+	TokenImage  string `json:"tokenImage" yaml:"tokenImage" toml:"tokenImage" env:"OTP_PORTAL_TOKEN_IMAGE" flag:"token-image" usage:"image URL of token shown by some authenticator apps, empty - none"`
+
+	// "test my token" page: attempts per user are limited
+	OTPTestMaxAttempts int      `json:"otpTestMaxAttempts" yaml:"otpTestMaxAttempts" toml:"otpTestMaxAttempts" env:"OTP_PORTAL_OTP_TEST_MAX_ATTEMPTS" flag:"otp-test-max-attempts" usage:"max OTP checks per user on 'test my token' page in otpTestWindow(token resyncs are limited the same way)"`
+	OTPTestWindow      Duration `json:"otpTestWindow" yaml:"otpTestWindow" toml:"otpTestWindow" env:"OTP_PORTAL_OTP_TEST_WINDOW" flag:"otp-test-window" usage:"window of OTP checks limit on 'test my token' page"`
+
+	// domain password re-entry(self-unlock, secret key) attempts limit
+	PasswordMaxAttempts int      `json:"passwordMaxAttempts" yaml:"passwordMaxAttempts" toml:"passwordMaxAttempts" env:"OTP_PORTAL_PASSWORD_MAX_ATTEMPTS" flag:"password-max-attempts" usage:"max domain password re-entries per user(self-unlock & secret key pages) in passwordWindow"`
+	PasswordWindow      Duration `json:"passwordWindow" yaml:"passwordWindow" toml:"passwordWindow" env:"OTP_PORTAL_PASSWORD_WINDOW" flag:"password-window" usage:"window of domain password re-entries limit"`
+
+	// self-unlock of locked MultiOTP token, 0 - disabled
+	SelfUnlockMaxPerDay int `json:"selfUnlockMaxPerDay" yaml:"selfUnlockMaxPerDay" toml:"selfUnlockMaxPerDay" env:"OTP_PORTAL_SELF_UNLOCK_MAX_PER_DAY" flag:"self-unlock-max-per-day" usage:"max self-unlocks of locked token per user in 24h(0 - self-unlock disabled)"`
+
+	// QR rendering(svg on QR page, png download & print page)
+	QRECC        string `json:"qrECC" yaml:"qrECC" toml:"qrECC" env:"OTP_PORTAL_QR_ECC" flag:"qr-ecc" usage:"QR error correction level: 'low', 'medium', 'quartile' or 'high'(always 'high' with qrLogo)"`
+	QRScale      int    `json:"qrScale" yaml:"qrScale" toml:"qrScale" env:"OTP_PORTAL_QR_SCALE" flag:"qr-scale" usage:"QR svg units per module"`
+	QRQuietZone  int    `json:"qrQuietZone" yaml:"qrQuietZone" toml:"qrQuietZone" env:"OTP_PORTAL_QR_QUIET_ZONE" flag:"qr-quiet-zone" usage:"QR border(modules)"`
+	QRDarkColor  string `json:"qrDarkColor" yaml:"qrDarkColor" toml:"qrDarkColor" env:"OTP_PORTAL_QR_DARK_COLOR" flag:"qr-dark-color" usage:"QR dark modules color('#RRGGBB')"`
+	QRLightColor string `json:"qrLightColor" yaml:"qrLightColor" toml:"qrLightColor" env:"OTP_PORTAL_QR_LIGHT_COLOR" flag:"qr-light-color" usage:"QR light modules & border color('#RRGGBB')"`
+	QRLogo       string `json:"qrLogo" yaml:"qrLogo" toml:"qrLogo" env:"OTP_PORTAL_QR_LOGO" flag:"qr-logo" usage:"logo(PNG/JPEG) in the middle of QR, empty - no logo"`
+	QRPngSize    int    `json:"qrPngSize" yaml:"qrPngSize" toml:"qrPngSize" env:"OTP_PORTAL_QR_PNG_SIZE" flag:"qr-png-size" usage:"default size(px) of QR png image"`
+
+	// QR is shown after explicit click for this time, then it's hidden & png isn't served
+	QRRevealTimeout Duration `json:"qrRevealTimeout" yaml:"qrRevealTimeout" toml:"qrRevealTimeout" env:"OTP_PORTAL_QR_REVEAL_TIMEOUT" flag:"qr-reveal-timeout" usage:"how long QR is shown(and its png is served) after 'Show QR' click"`
+	// enrollment mode: QR is shown only until user confirms it with valid OTP(marker is kept in session db)
+	EnrollmentMode bool `json:"enrollmentMode" yaml:"enrollmentMode" toml:"enrollmentMode" env:"OTP_PORTAL_ENROLLMENT_MODE" flag:"enrollment-mode" usage:"show QR only until enrollment is confirmed with valid OTP, then only reissue is offered"`
+
+	// session store & its DB
+	SessionStore string   `json:"sessionStore" yaml:"sessionStore" toml:"sessionStore" env:"OTP_PORTAL_SESSION_STORE" flag:"session-store" usage:"session store: 'memory', 'sqlite', 'postgres' or 'mysql'"`
+	DbHost       string   `json:"dbHost" yaml:"dbHost" toml:"dbHost" env:"OTP_PORTAL_DB_HOST" flag:"db-host" usage:"session db host(postgres/mysql)"`
+	DbPort       int      `json:"dbPort" yaml:"dbPort" toml:"dbPort" env:"OTP_PORTAL_DB_PORT" flag:"db-port" usage:"session db port(postgres/mysql), 0 - driver's default"`
+	DbTLS        string   `json:"dbTLS" yaml:"dbTLS" toml:"dbTLS" env:"OTP_PORTAL_DB_TLS" flag:"db-tls" usage:"session db TLS: mysql - 'true'/'false'/'skip-verify'/'preferred', postgres - sslmode('disable'/'require'/'verify-ca'/'verify-full')"`
+	DbName       string   `json:"dbName" yaml:"dbName" toml:"dbName" env:"OTP_PORTAL_DB_NAME" flag:"db" usage:"session db name(postgres/mysql)"`
+	DbPath       string   `json:"dbPath" yaml:"dbPath" toml:"dbPath" env:"OTP_PORTAL_DB_PATH" flag:"db-path" usage:"session db file(sqlite)"`
+	DbRetries    int      `json:"dbRetries" yaml:"dbRetries" toml:"dbRetries" env:"OTP_PORTAL_DB_RETRIES" flag:"db-retries" usage:"number of session db connect retries at startup"`
+	DbBackoff    Duration `json:"dbBackoff" yaml:"dbBackoff" toml:"dbBackoff" env:"OTP_PORTAL_DB_BACKOFF" flag:"db-backoff" usage:"wait before first session db connect retry, doubles every retry"`
+	DbMaxBackoff Duration `json:"dbMaxBackoff" yaml:"dbMaxBackoff" toml:"dbMaxBackoff" env:"OTP_PORTAL_DB_MAX_BACKOFF" flag:"db-max-backoff" usage:"max wait between session db connect retries"`
+	DbUser       string   `json:"dbUser" yaml:"dbUser" toml:"dbUser" env:"OTP_PORTAL_DB_USER"`
+	DbPass       string   `json:"dbPass" yaml:"dbPass" toml:"dbPass" env:"OTP_PORTAL_DB_PASS" secret:"true"`
+
+	// domain data
+	UserDomainFQDN       string `json:"userDomainFQDN" yaml:"userDomainFQDN" toml:"userDomainFQDN" env:"OTP_PORTAL_USER_DOMAIN_FQDN" flag:"user-domain-fqdn" usage:"FQDN of domain to auth users"`
+	UserDomainBaseDN     string `json:"userDomainBaseDN" yaml:"userDomainBaseDN" toml:"userDomainBaseDN" env:"OTP_PORTAL_USER_DOMAIN_BASE_DN" flag:"user-domain-basedn" usage:"Base DN of domain to auth users"`
+	QrDomainFQDN         string `json:"qrDomainFQDN" yaml:"qrDomainFQDN" toml:"qrDomainFQDN" env:"OTP_PORTAL_QR_DOMAIN_FQDN" flag:"qr-domain-fqdn" usage:"FQDN of domain MultiOTP bound with"`
+	QrDomainBaseDN       string `json:"qrDomainBaseDN" yaml:"qrDomainBaseDN" toml:"qrDomainBaseDN" env:"OTP_PORTAL_QR_DOMAIN_BASE_DN" flag:"qr-domain-basedn" usage:"Base DN of domain MultiOTP bound with"`
+	QrDomainBindUser     string `json:"qrDomainBindUser" yaml:"qrDomainBindUser" toml:"qrDomainBindUser" env:"OTP_PORTAL_QR_DOMAIN_BIND_USER" flag:"qr-domain-bind-user" usage:"Bind user of domain MultiOTP bound with"`
+	QrDomainBindUserPass string `json:"qrDomainBindUserPass" yaml:"qrDomainBindUserPass" toml:"qrDomainBindUserPass" env:"OTP_PORTAL_QR_DOMAIN_BIND_USER_PASS" secret:"true"`
+
+	// local encrypted secrets file for 'store:NAME' references and its master key
+	SecretsFile      string `json:"secretsFile" yaml:"secretsFile" toml:"secretsFile" env:"OTP_PORTAL_SECRETS_FILE" flag:"secrets-file" usage:"full path to encrypted secrets file(for 'store:NAME' secret references)"`
+	SecretsMasterKey string `json:"secretsMasterKey" yaml:"secretsMasterKey" toml:"secretsMasterKey" env:"OTP_PORTAL_SECRETS_MASTER_KEY" secret:"true"`
+
+	// 2fa(PrivacyIdea)
+	SecondFactorOn     bool   `json:"secondFactorOn" yaml:"secondFactorOn" toml:"secondFactorOn" env:"OTP_PORTAL_2FA" flag:"2fa" usage:"Use (PrivacyIdea API) provider for second factor auth"`
+	MfaUrl             string `json:"mfaUrl" yaml:"mfaUrl" toml:"mfaUrl" env:"OTP_PORTAL_MFA_URL" flag:"mfa-url" usage:"PrivacyIdea base URL"`
+	MfaTriggerUser     string `json:"mfaTriggerUser" yaml:"mfaTriggerUser" toml:"mfaTriggerUser" env:"OTP_PORTAL_MFA_TRIGGER_USER" flag:"mfa-trigger-user" usage:"PrivacyIdea trigger(admin) user"`
+	MfaTriggerUserPass string `json:"mfaTriggerUserPass" yaml:"mfaTriggerUserPass" toml:"mfaTriggerUserPass" env:"OTP_PORTAL_MFA_TRIGGER_USER_PASS" secret:"true"`
+	MfaCAFile          string `json:"mfaCAFile" yaml:"mfaCAFile" toml:"mfaCAFile" env:"OTP_PORTAL_MFA_CA_FILE" flag:"mfa-ca-file" usage:"CA cert(PEM) of PrivacyIdea TLS cert, empty - cert isn't verified"`
+}
+
+// Default returns Config with built-in default values
+func Default() Config {
+	workDir := vafswork.GetExePath()
+
+	return Config{
+		Addr:                ":3000",
+		AdminAddr:           "127.0.0.1:9090",
+		TLSCert:             workDir + "/tls" + "/" + "cert.pem",
+		TLSKey:              workDir + "/tls" + "/" + "key.pem",
+		IdleTimeout:         Duration{time.Minute},
+		ReadTimeout:         Duration{10 * time.Second},
+		WriteTimeout:        Duration{15 * time.Second},
+		ShutdownTimeout:     Duration{2 * time.Minute},
+		SessionLifetime:     Duration{30 * time.Minute},
+		LogDir:              workDir + "/logs" + "_" + "OTP-Portal",
+		KeepLogs:            30,
+		Lang:                "ru",
+		MultiOTPBackend:     multiotp.BackendCLI,
+		MultiOTPBinPath:     multiotp.DefaultBinPath,
+		MultiOTPPHPPath:     multiotp.DefaultPHPPath,
+		ReissueStrategy:     multiotp.StrategyFullSync,
+		MultiOTPTimeout:     Duration{10 * time.Second},
+		MultiOTPSyncTimeout: Duration{10 * time.Minute},
+		OTPTestMaxAttempts:  5,
+		OTPTestWindow:       Duration{15 * time.Minute},
+		PasswordMaxAttempts: 5,
+		PasswordWindow:      Duration{15 * time.Minute},
+		SelfUnlockMaxPerDay: 3,
+		QRECC:               qrwork.DefaultOptions().ECC,
+		QRScale:             qrwork.DefaultOptions().Scale,
+		QRQuietZone:         qrwork.DefaultOptions().QuietZone,
+		QRDarkColor:         qrwork.DefaultOptions().Dark,
+		QRLightColor:        qrwork.DefaultOptions().Light,
+		QRRevealTimeout:     Duration{time.Minute},
+		QRPngSize:           300,
+		SessionStore:        sessionstore.MySQL,
+		DbHost:              "127.0.0.1",
+		DbName:              "otpportal",
+		DbPath:              workDir + "/" + "otpportal.db",
+		DbRetries:           5,
+		DbBackoff:           Duration{2 * time.Second},
+		DbMaxBackoff:        Duration{30 * time.Second},
+	}
+}
+
+// Loader builds Config from all sources.
+// Keep the Loader to call Load() again(ex. on config reload),
+// flags are parsed only once.
+type Loader struct {
+	flagSet    *flag.FlagSet
+	configPath *string
+	flagValues Config
+	embedded   []byte
+	redactor   *secrets.Redactor
+}
+
+// NewLoader registers '-config' and all config flags on fs.
+// embedded is the fallback config file content(may be nil).
+// fs must be parsed before calling Load().
+func NewLoader(fs *flag.FlagSet, embedded []byte) *Loader {
+	l := &Loader{
+		flagSet:    fs,
+		flagValues: Default(),
+		embedded:   embedded,
+		redactor:   secrets.NewRedactor(),
+	}
+
+	l.configPath = fs.String("config", "", "full path to config file(.json/.yaml/.yml/.toml), may be set by "+configPathEnv+" env")
+
+	v := reflect.ValueOf(&l.flagValues).Elem()
+	t := v.Type()
+	for i := range t.NumField() {
+		name := t.Field(i).Tag.Get("flag")
+		if len(name) == 0 {
+			continue
+		}
+		fs.Var(fieldValue{v.Field(i)}, name, t.Field(i).Tag.Get("usage"))
+	}
+
+	return l
+}
+
+// ConfigPath returns config file path in use, empty if embedded/default config is used
+func (l *Loader) ConfigPath() string {
+	if len(*l.configPath) != 0 {
+		return *l.configPath
+	}
+	return os.Getenv(configPathEnv)
+}
+
+// Redactor returns all secrets resolved by Load()(including previous loads),
+// use it with secrets.NewRedactHandler to hide them in logs
+func (l *Loader) Redactor() *secrets.Redactor {
+	return l.redactor
+}
+
+// Load reads all config sources, resolves secrets and validates the result
+func (l *Loader) Load() (*Config, error) {
+	cfg := Default()
+
+	// config file or embedded data file
+	path := l.ConfigPath()
+	switch {
+	case len(path) != 0:
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	case len(l.embedded) != 0:
+		if err := cfg.decode(l.embedded, ".json"); err != nil {
+			return nil, fmt.Errorf("embedded data file: %w", err)
+		}
+	}
+
+	// environment
+	if err := cfg.applyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+
+	// explicitly set flags
+	l.applyFlags(&cfg)
+
+	// secret references
+	if err := l.resolveSecrets(&cfg); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// resolve secret references by 'secret' field tag.
+// Master key is resolved first: it's needed for 'store:' references.
+func (l *Loader) resolveSecrets(c *Config) error {
+	masterKey, err := secrets.NewResolver("", "").Resolve(c.SecretsMasterKey)
+	if err != nil {
+		return fmt.Errorf("secretsMasterKey: %w", err)
+	}
+	c.SecretsMasterKey = masterKey
+	l.redactor.Add(masterKey)
+
+	resolver := secrets.NewResolver(c.SecretsFile, masterKey)
+
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := range t.NumField() {
+		if t.Field(i).Tag.Get("secret") != "true" || t.Field(i).Name == "SecretsMasterKey" {
+			continue
+		}
+
+		key := t.Field(i).Tag.Get("json")
+		value, err := resolver.Resolve(v.Field(i).String())
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+
+		v.Field(i).SetString(value)
+		l.redactor.Add(value)
+	}
+
+	return nil
+}
+
+// read config file and decode it by extension
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	if err := c.decode(data, strings.ToLower(filepath.Ext(path))); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// decode config file content, unknown keys are errors
+func (c *Config) decode(data []byte, ext string) error {
+	switch ext {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		return dec.Decode(c)
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		return dec.Decode(c)
+	case ".toml":
+		md, err := toml.Decode(string(data), c)
+		if err != nil {
+			return err
+		}
+		if undecoded := md.Undecoded(); len(undecoded) != 0 {
+			return fmt.Errorf("unknown keys: %v", undecoded)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported config file extension %q(use .json, .yaml, .yml or .toml)", ext)
+	}
+}
+
+// apply env vars by 'env' field tag
+func (c *Config) applyEnv(lookup func(string) (string, bool)) error {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+
+	for i := range t.NumField() {
+		name := t.Field(i).Tag.Get("env")
+		if len(name) == 0 {
+			continue
+		}
+
+		value, ok := lookup(name)
+		if !ok {
+			continue
+		}
+
+		if err := (fieldValue{v.Field(i)}).Set(value); err != nil {
+			return fmt.Errorf("env %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// copy only flags which were set in command line
+func (l *Loader) applyFlags(c *Config) {
+	src := reflect.ValueOf(&l.flagValues).Elem()
+	dst := reflect.ValueOf(c).Elem()
+	t := src.Type()
+
+	set := make(map[string]bool)
+	l.flagSet.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	for i := range t.NumField() {
+		if set[t.Field(i).Tag.Get("flag")] {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+}
+
+// Validate checks every field and returns all found problems at once
+func (c *Config) Validate() error {
+	var errs []string
+
+	fail := func(key, format string, args ...any) {
+		errs = append(errs, key+": "+fmt.Sprintf(format, args...))
+	}
+
+	required := func(key, value string) {
+		if len(strings.TrimSpace(value)) == 0 {
+			fail(key, "must not be empty")
+		}
+	}
+
+	fileExists := func(key, path string) {
+		if len(path) == 0 {
+			fail(key, "must not be empty")
+			return
+		}
+		if _, err := os.Stat(path); err != nil {
+			fail(key, "file %q not found: %v", path, err)
+		}
+	}
+
+	positive := func(key string, d Duration) {
+		if d.Duration <= 0 {
+			fail(key, "must be positive, got %s", d)
+		}
+	}
+
+	required("addr", c.Addr)
+	if len(c.AdminAddr) != 0 && c.AdminAddr == c.Addr {
+		fail("adminAddr", "must differ from addr(%q)", c.Addr)
+	}
+	fileExists("tlsCert", c.TLSCert)
+	fileExists("tlsKey", c.TLSKey)
+	positive("idleTimeout", c.IdleTimeout)
+	positive("readTimeout", c.ReadTimeout)
+	positive("writeTimeout", c.WriteTimeout)
+	positive("shutdownTimeout", c.ShutdownTimeout)
+	positive("sessionLifetime", c.SessionLifetime)
+	if c.ConfigWatchInterval.Duration < 0 {
+		fail("configWatchInterval", "must not be negative, got %s", c.ConfigWatchInterval)
+	}
+
+	required("logDir", c.LogDir)
+	if c.KeepLogs < 1 {
+		fail("keepLogs", "must be at least 1, got %d", c.KeepLogs)
+	}
+
+	if c.Lang != "ru" && c.Lang != "en" {
+		fail("lang", "must be 'ru' or 'en', got %q", c.Lang)
+	}
+
+	switch c.MultiOTPBackend {
+	case multiotp.BackendCLI:
+		fileExists("multiOTPBinPath", c.MultiOTPBinPath)
+		if multiotp.IsPHPScript(c.MultiOTPBinPath) {
+			if _, err := exec.LookPath(c.MultiOTPPHPPath); err != nil {
+				fail("multiOTPPHPPath", "PHP interpreter is needed to run %q: %v", c.MultiOTPBinPath, err)
+			}
+		}
+	case multiotp.BackendHTTP:
+		required("multiOTPAgentURL", c.MultiOTPAgentURL)
+		required("multiOTPAgentToken", c.MultiOTPAgentToken)
+		if len(c.MultiOTPAgentURL) != 0 && !strings.HasPrefix(c.MultiOTPAgentURL, "https://") && !strings.HasPrefix(c.MultiOTPAgentURL, "http://") {
+			fail("multiOTPAgentURL", "must start with 'http://' or 'https://', got %q", c.MultiOTPAgentURL)
+		}
+		if len(c.MultiOTPAgentCAFile) != 0 {
+			fileExists("multiOTPAgentCAFile", c.MultiOTPAgentCAFile)
+		}
+	default:
+		fail("multiOTPBackend", "must be one of %q, got %q", multiotp.Backends, c.MultiOTPBackend)
+	}
+	if !slices.Contains(multiotp.Strategies, c.ReissueStrategy) {
+		fail("reissueStrategy", "must be one of %q, got %q", multiotp.Strategies, c.ReissueStrategy)
+	}
+	positive("multiOTPTimeout", c.MultiOTPTimeout)
+	positive("multiOTPSyncTimeout", c.MultiOTPSyncTimeout)
+	profileNames := make(map[string]bool)
+	for i, profile := range c.TokenProfiles {
+		key := fmt.Sprintf("tokenProfiles[%d]", i)
+		if err := profile.Validate(); err != nil {
+			fail(key, "%s", strings.ReplaceAll(err.Error(), "\n", "; "))
+		}
+		if profileNames[profile.Name] {
+			fail(key, "duplicate name %q", profile.Name)
+		}
+		profileNames[profile.Name] = true
+	}
+	if _, err := otpauth.NewRewrite(c.TokenIssuer, "", ""); err != nil {
+		fail("tokenIssuer", "%v", err)
+	}
+	if _, err := otpauth.NewRewrite("", c.TokenLabel, ""); err != nil {
+		fail("tokenLabel", "%v", err)
+	}
+	if _, err := otpauth.NewRewrite("", "", c.TokenImage); err != nil {
+		fail("tokenImage", "%v", err)
+	}
+	if c.OTPTestMaxAttempts < 1 {
+		fail("otpTestMaxAttempts", "must be at least 1, got %d", c.OTPTestMaxAttempts)
+	}
+	positive("otpTestWindow", c.OTPTestWindow)
+	if c.PasswordMaxAttempts < 1 {
+		fail("passwordMaxAttempts", "must be at least 1, got %d", c.PasswordMaxAttempts)
+	}
+	positive("passwordWindow", c.PasswordWindow)
+	if c.SelfUnlockMaxPerDay < 0 {
+		fail("selfUnlockMaxPerDay", "must not be negative, got %d", c.SelfUnlockMaxPerDay)
+	}
+	positive("qrRevealTimeout", c.QRRevealTimeout)
+	if c.QRPngSize < qrwork.MinPNGSize || c.QRPngSize > qrwork.MaxPNGSize {
+		fail("qrPngSize", "must be in %d..%d, got %d", qrwork.MinPNGSize, qrwork.MaxPNGSize, c.QRPngSize)
+	}
+	if err := c.QROptions(nil).Validate(); err != nil {
+		fail("qr", "%s", strings.ReplaceAll(err.Error(), "\n", "; "))
+	}
+	if len(c.QRLogo) != 0 {
+		if _, err := qrwork.LoadLogo(c.QRLogo); err != nil {
+			fail("qrLogo", "%v", err)
+		}
+	}
+
+	// session store
+	switch c.SessionStore {
+	case sessionstore.Memory:
+	case sessionstore.SQLite:
+		required("dbPath", c.DbPath)
+	case sessionstore.Postgres, sessionstore.MySQL:
+		required("dbHost", c.DbHost)
+		required("dbName", c.DbName)
+		required("dbUser", c.DbUser)
+		if c.DbPort < 0 || c.DbPort > 65535 {
+			fail("dbPort", "must be 0..65535, got %d", c.DbPort)
+		}
+		tlsValues := []string{"", "true", "false", "skip-verify", "preferred"}
+		if c.SessionStore == sessionstore.Postgres {
+			tlsValues = []string{"", "disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+		}
+		if !slices.Contains(tlsValues, c.DbTLS) {
+			fail("dbTLS", "must be one of %q for %s, got %q", tlsValues[1:], c.SessionStore, c.DbTLS)
+		}
+		if c.DbRetries < 0 {
+			fail("dbRetries", "must not be negative, got %d", c.DbRetries)
+		}
+		positive("dbBackoff", c.DbBackoff)
+		if c.DbMaxBackoff.Duration < c.DbBackoff.Duration {
+			fail("dbMaxBackoff", "must not be less than dbBackoff(%s), got %s", c.DbBackoff, c.DbMaxBackoff)
+		}
+	default:
+		fail("sessionStore", "must be one of %q, got %q", sessionstore.Kinds, c.SessionStore)
+	}
+	// memory store loses enrollment markers on restart: every QR would be shown again
+	if c.EnrollmentMode && c.SessionStore == sessionstore.Memory {
+		fail("enrollmentMode", "needs persistent session store(sqlite, postgres or mysql), got %q", c.SessionStore)
+	}
+
+	required("userDomainFQDN", c.UserDomainFQDN)
+	required("userDomainBaseDN", c.UserDomainBaseDN)
+	required("qrDomainFQDN", c.QrDomainFQDN)
+	required("qrDomainBaseDN", c.QrDomainBaseDN)
+	required("qrDomainBindUser", c.QrDomainBindUser)
+	required("qrDomainBindUserPass", c.QrDomainBindUserPass)
+
+	// mfa data is needed only with '2fa' on
+	if c.SecondFactorOn {
+		required("mfaUrl", c.MfaUrl)
+		required("mfaTriggerUser", c.MfaTriggerUser)
+		required("mfaTriggerUserPass", c.MfaTriggerUserPass)
+		if len(c.MfaUrl) != 0 && !strings.HasPrefix(c.MfaUrl, "https://") && !strings.HasPrefix(c.MfaUrl, "http://") {
+			fail("mfaUrl", "must start with 'http://' or 'https://', got %q", c.MfaUrl)
+		}
+		if len(c.MfaCAFile) != 0 {
+			fileExists("mfaCAFile", c.MfaCAFile)
+		}
+	}
+
+	if len(errs) != 0 {
+		return fmt.Errorf("invalid config:\n\t%s", strings.Join(errs, "\n\t"))
+	}
+
+	return nil
+}
+
+// QR rendering options of config, logo is loaded by caller(nil - no logo)
+func (c *Config) QROptions(logo *qrwork.Logo) qrwork.Options {
+	return qrwork.Options{
+		ECC:       c.QRECC,
+		Scale:     c.QRScale,
+		QuietZone: c.QRQuietZone,
+		Dark:      c.QRDarkColor,
+		Light:     c.QRLightColor,
+		Logo:      logo,
+	}
+}
+
+// Duration is time.Duration which may be set as string("30m", "15s")
+// in config files, env vars and flags
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalText is used by JSON, YAML and TOML decoders
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// MarshalText is the reverse of UnmarshalText
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// fieldValue is flag.Value for Config field(string, int, bool or Duration)
+type fieldValue struct {
+	v reflect.Value
+}
+
+func (f fieldValue) String() string {
+	if !f.v.IsValid() {
+		return ""
+	}
+	return fmt.Sprint(f.v.Interface())
+}
+
+func (f fieldValue) Set(s string) error {
+	switch f.v.Interface().(type) {
+	case string:
+		f.v.SetString(s)
+	case int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", s)
+		}
+		f.v.SetInt(int64(n))
+	case bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", s)
+		}
+		f.v.SetBool(b)
+	case Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration(ex. '30s', '15m')", s)
+		}
+		f.v.Set(reflect.ValueOf(Duration{d}))
+	default:
+		return fmt.Errorf("unsupported config field type %s", f.v.Type())
+	}
+	return nil
+}
+
+// IsBoolFlag allows bool flags without value(ex. '-2fa')
+func (f fieldValue) IsBoolFlag() bool {
+	return f.v.IsValid() && f.v.Kind() == reflect.Bool
+}
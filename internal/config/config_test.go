@@ -0,0 +1,231 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// minimal valid config file values(http backend & memory store: no files but TLS ones are needed)
+func testSettings(t *testing.T) map[string]any {
+	t.Helper()
+
+	dir := t.TempDir()
+	for _, name := range []string{"cert.pem", "key.pem"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("test"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return map[string]any{
+		"tlsCert":              filepath.Join(dir, "cert.pem"),
+		"tlsKey":               filepath.Join(dir, "key.pem"),
+		"multiOTPBackend":      "http",
+		"multiOTPAgentURL":     "https://multiotp.example.com:8443",
+		"multiOTPAgentToken":   "agent-token",
+		"sessionStore":         "memory",
+		"userDomainFQDN":       "example.com",
+		"userDomainBaseDN":     "DC=example,DC=com",
+		"qrDomainFQDN":         "example.com",
+		"qrDomainBaseDN":       "DC=example,DC=com",
+		"qrDomainBindUser":     "multiotp",
+		"qrDomainBindUserPass": "bind-pass",
+	}
+}
+
+// write settings to config file of ext format
+func writeConfig(t *testing.T, settings map[string]any, ext string) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	var err error
+	switch ext {
+	case ".json":
+		err = json.NewEncoder(&buf).Encode(settings)
+	case ".yaml":
+		err = yaml.NewEncoder(&buf).Encode(settings)
+	case ".toml":
+		err = toml.NewEncoder(&buf).Encode(settings)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "config"+ext)
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Loader with parsed args, config path env is unset
+func newTestLoader(t *testing.T, embedded []byte, args ...string) *Loader {
+	t.Helper()
+
+	t.Setenv(configPathEnv, "")
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	l := NewLoader(fs, embedded)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// defaults < file < env < flags, for every file format
+func TestLoadPrecedence(t *testing.T) {
+	for _, ext := range []string{".json", ".yaml", ".toml"} {
+		t.Run(ext, func(t *testing.T) {
+			settings := testSettings(t)
+			settings["addr"] = ":4000"
+			settings["lang"] = "en"
+			settings["keepLogs"] = 10
+			settings["idleTimeout"] = "2m"
+			path := writeConfig(t, settings, ext)
+
+			t.Setenv("OTP_PORTAL_KEEP_LOGS", "20")
+			t.Setenv("OTP_PORTAL_ADDR", ":5000")
+			l := newTestLoader(t, nil, "-config", path, "-addr", ":6000")
+
+			cfg, err := l.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tests := []struct {
+				key       string
+				got, want any
+			}{
+				{"addr(flag)", cfg.Addr, ":6000"},
+				{"keepLogs(env)", cfg.KeepLogs, 20},
+				{"lang(file)", cfg.Lang, "en"},
+				{"idleTimeout(file)", cfg.IdleTimeout.Duration, 2 * time.Minute},
+				{"readTimeout(default)", cfg.ReadTimeout.Duration, Default().ReadTimeout.Duration},
+				{"qrPngSize(default)", cfg.QRPngSize, Default().QRPngSize},
+			}
+			for _, tt := range tests {
+				if tt.got != tt.want {
+					t.Errorf("%s = %v, want %v", tt.key, tt.got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// embedded data.json is used only without config file
+func TestLoadEmbedded(t *testing.T) {
+	settings := testSettings(t)
+	settings["lang"] = "en"
+	embedded, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := newTestLoader(t, embedded).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Lang != "en" || cfg.QrDomainBindUser != "multiotp" {
+		t.Errorf("embedded config isn't used: lang %q, qrDomainBindUser %q", cfg.Lang, cfg.QrDomainBindUser)
+	}
+
+	settings["lang"] = "ru"
+	path := writeConfig(t, settings, ".json")
+	cfg, err = newTestLoader(t, embedded, "-config", path).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Lang != "ru" {
+		t.Errorf("config file must replace embedded config: lang %q", cfg.Lang)
+	}
+
+	if _, err := newTestLoader(t, []byte("{bad json")).Load(); err == nil || !strings.Contains(err.Error(), "embedded data file") {
+		t.Errorf("bad embedded config: got %v", err)
+	}
+}
+
+func TestLoadBadDurations(t *testing.T) {
+	settings := testSettings(t)
+	settings["readTimeout"] = "ten seconds"
+	for _, ext := range []string{".json", ".yaml", ".toml"} {
+		path := writeConfig(t, settings, ext)
+		if _, err := newTestLoader(t, nil, "-config", path).Load(); err == nil {
+			t.Errorf("%s: bad duration in file must fail", ext)
+		}
+	}
+
+	path := writeConfig(t, testSettings(t), ".json")
+	t.Setenv("OTP_PORTAL_IDLE_TIMEOUT", "5 minutes")
+	_, err := newTestLoader(t, nil, "-config", path).Load()
+	if err == nil || !strings.Contains(err.Error(), "OTP_PORTAL_IDLE_TIMEOUT") {
+		t.Errorf("bad duration in env: got %v, want OTP_PORTAL_IDLE_TIMEOUT error", err)
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(&bytes.Buffer{})
+	NewLoader(fs, nil)
+	if err := fs.Parse([]string{"-write-timeout", "15"}); err == nil {
+		t.Error("bad duration flag must fail")
+	}
+}
+
+// unknown enum values are reported together with other problems
+func TestLoadBadEnums(t *testing.T) {
+	settings := testSettings(t)
+	settings["lang"] = "de"
+	settings["reissueStrategy"] = "fast"
+	settings["sessionStore"] = "redis"
+	settings["multiOTPBackend"] = "soap"
+	settings["qrECC"] = "max"
+	path := writeConfig(t, settings, ".yaml")
+
+	_, err := newTestLoader(t, nil, "-config", path).Load()
+	if err == nil {
+		t.Fatal("bad enum values must fail")
+	}
+	for _, key := range []string{"lang:", "reissueStrategy:", "sessionStore:", "multiOTPBackend:", "qr:"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("error doesn't report %s\n%v", key, err)
+		}
+	}
+
+	settings = testSettings(t)
+	settings["unknownKey"] = true
+	path = writeConfig(t, settings, ".toml")
+	if _, err := newTestLoader(t, nil, "-config", path).Load(); err == nil {
+		t.Error("unknown key must fail")
+	}
+}
+
+// Validate reports every problem, not only the first one
+func TestValidateAllErrors(t *testing.T) {
+	cfg := Default()
+	cfg.Addr = ""
+	cfg.TLSCert = filepath.Join(t.TempDir(), "missing.pem")
+	cfg.KeepLogs = 0
+	cfg.SessionStore = "memory"
+	cfg.EnrollmentMode = true
+	cfg.ReadTimeout = Duration{}
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("invalid config must fail")
+	}
+
+	keys := []string{
+		"addr:", "tlsCert:", "tlsKey:", "readTimeout:", "keepLogs:", "enrollmentMode:",
+		"userDomainFQDN:", "userDomainBaseDN:", "qrDomainFQDN:", "qrDomainBaseDN:", "qrDomainBindUser:", "qrDomainBindUserPass:",
+	}
+	for _, key := range keys {
+		if !strings.Contains(err.Error(), "\n\t"+key) {
+			t.Errorf("error doesn't report %s\n%v", key, err)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Job states
+type State string
+
+const (
+	Queued   State = "queued"
+	Deleting State = "deleting"
+	Syncing  State = "syncing"
+	Creating State = "creating"
+	Done     State = "done"
+	Failed   State = "failed"
+)
+
+// Finished returns true for Done & Failed states
+func (s State) Finished() bool {
+	return s == Done || s == Failed
+}
+
+// finished jobs are kept for polling that long
+const finishedTTL = time.Hour
+
+var (
+	ErrQueueFull    = errors.New("jobs queue is full")
+	ErrShuttingDown = errors.New("jobs queue is shutting down")
+	// returned with user's active job if it has other token profile
+	ErrOtherProfile = errors.New("user's active job has other token profile")
+)
+
+// Job is a snapshot of background job for one user
+type Job struct {
+	ID         string     `json:"id"`
+	User       string     `json:"-"`
+	Profile    string     `json:"-"` // token profile name, empty - default token
+	State      State      `json:"state"`
+	Error      string     `json:"-"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	UpdatedAt  time.Time  `json:"updatedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// RunFunc does the job for user(with token profile, may be empty),
+// calls progress on every state change.
+// ctx is cancelled if Shutdown's wait is over.
+type RunFunc func(ctx context.Context, user, profile string, progress func(State)) error
+
+// Queue runs jobs in background workers, one active job per user.
+// Jobs are kept in memory only.
+type Queue struct {
+	mu       sync.Mutex
+	jobs     map[string]*Job
+	byUser   map[string]string // user -> active job ID
+	work     chan string
+	run      RunFunc
+	stopping bool
+	workers  sync.WaitGroup
+
+	// cancels running jobs
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewQueue starts workers, size is max number of queued jobs
+func NewQueue(workers, size int, run RunFunc) *Queue {
+	q := &Queue{
+		jobs:   make(map[string]*Job),
+		byUser: make(map[string]string),
+		work:   make(chan string, size),
+		run:    run,
+	}
+	q.ctx, q.cancel = context.WithCancel(context.Background())
+
+	for range workers {
+		q.workers.Add(1)
+		go q.worker()
+	}
+
+	return q
+}
+
+// Enqueue adds job for user with token profile(may be empty)
+// or returns user's active(queued/running) job,
+// so repeated requests don't start the same job twice.
+// Active job with other profile is returned with ErrOtherProfile:
+// the requested profile isn't applied.
+func (q *Queue) Enqueue(user, profile string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.stopping {
+		return Job{}, ErrShuttingDown
+	}
+
+	if id, ok := q.byUser[user]; ok {
+		job := *q.jobs[id]
+		if job.Profile != profile {
+			return job, ErrOtherProfile
+		}
+		return job, nil
+	}
+
+	q.prune()
+
+	now := time.Now()
+	job := &Job{
+		ID:        newID(),
+		User:      user,
+		Profile:   profile,
+		State:     Queued,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	select {
+	case q.work <- job.ID:
+	default:
+		return Job{}, ErrQueueFull
+	}
+
+	q.jobs[job.ID] = job
+	q.byUser[user] = job.ID
+
+	return *job, nil
+}
+
+// Get returns job snapshot by ID
+func (q *Queue) Get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+
+	return *job, true
+}
+
+// Shutdown stops accepting jobs and waits for workers:
+// running jobs are finished, queued ones are failed.
+// If ctx is done first, running jobs are cancelled.
+func (q *Queue) Shutdown(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.stopping {
+		q.stopping = true
+		close(q.work)
+	}
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		q.cancel()
+		return ctx.Err()
+	}
+}
+
+func (q *Queue) worker() {
+	defer q.workers.Done()
+
+	for id := range q.work {
+		q.mu.Lock()
+		stopping := q.stopping
+		user, profile := q.jobs[id].User, q.jobs[id].Profile
+		q.mu.Unlock()
+
+		if stopping {
+			q.finish(id, ErrShuttingDown)
+			continue
+		}
+
+		err := q.run(q.ctx, user, profile, func(state State) {
+			q.setState(id, state)
+		})
+		q.finish(id, err)
+	}
+}
+
+func (q *Queue) setState(id string, state State) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job := q.jobs[id]
+	job.State = state
+	job.UpdatedAt = time.Now()
+}
+
+// set Done/Failed state, user may start a new job after it
+func (q *Queue) finish(id string, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job := q.jobs[id]
+	now := time.Now()
+	job.State = Done
+	if err != nil {
+		job.State = Failed
+		job.Error = err.Error()
+	}
+	job.UpdatedAt = now
+	job.FinishedAt = &now
+
+	delete(q.byUser, job.User)
+}
+
+// remove old finished jobs, q.mu must be locked
+func (q *Queue) prune() {
+	for id, job := range q.jobs {
+		if job.FinishedAt != nil && time.Since(*job.FinishedAt) > finishedTTL {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+// random job ID
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
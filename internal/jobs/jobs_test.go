@@ -0,0 +1,282 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// RunFunc blocked until released, reports started users
+type testRun struct {
+	started chan string
+	release chan error
+}
+
+func newTestRun() *testRun {
+	return &testRun{started: make(chan string, 10), release: make(chan error, 10)}
+}
+
+func (r *testRun) run(ctx context.Context, user, profile string, progress func(State)) error {
+	progress(Deleting)
+	r.started <- user
+	select {
+	case err := <-r.release:
+		progress(Syncing)
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *testRun) waitStarted(t *testing.T, want string) {
+	t.Helper()
+
+	select {
+	case user := <-r.started:
+		if user != want {
+			t.Fatalf("job of %q started, want %q", user, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("job of %q hasn't started", want)
+	}
+}
+
+// wait for job to be finished
+func waitFinished(t *testing.T, q *Queue, id string) Job {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, ok := q.Get(id)
+		if !ok {
+			t.Fatalf("job %s not found", id)
+		}
+		if job.State.Finished() {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job isn't finished, state %q", job.State)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestEnqueueDedup(t *testing.T) {
+	r := newTestRun()
+	q := NewQueue(1, 10, r.run)
+	t.Cleanup(func() { q.Shutdown(context.Background()) })
+
+	job, err := q.Enqueue("alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.waitStarted(t, "alice")
+
+	// active job is returned, not started twice
+	again, err := q.Enqueue("alice", "")
+	if err != nil || again.ID != job.ID {
+		t.Errorf("repeated enqueue: got %s, %v; want active job %s", again.ID, err, job.ID)
+	}
+
+	// other profile isn't applied silently
+	other, err := q.Enqueue("alice", "compliance")
+	if !errors.Is(err, ErrOtherProfile) || other.ID != job.ID || other.Profile != "" {
+		t.Errorf("other profile: got %s(%q), %v; want active job %s with ErrOtherProfile", other.ID, other.Profile, err, job.ID)
+	}
+
+	bob, err := q.Enqueue("bob", "compliance")
+	if err != nil || bob.ID == job.ID || bob.Profile != "compliance" {
+		t.Errorf("other user: got %+v, %v", bob, err)
+	}
+
+	r.release <- nil
+	r.release <- nil
+	waitFinished(t, q, job.ID)
+	waitFinished(t, q, bob.ID)
+
+	// finished job doesn't block new one
+	next, err := q.Enqueue("alice", "compliance")
+	if err != nil || next.ID == job.ID {
+		t.Errorf("enqueue after finish: got %s, %v; want new job", next.ID, err)
+	}
+	r.release <- nil
+	waitFinished(t, q, next.ID)
+}
+
+func TestEnqueueQueueFull(t *testing.T) {
+	r := newTestRun()
+	q := NewQueue(1, 1, r.run)
+	t.Cleanup(func() { q.Shutdown(context.Background()) })
+
+	// worker is busy, one job is queued
+	if _, err := q.Enqueue("alice", ""); err != nil {
+		t.Fatal(err)
+	}
+	r.waitStarted(t, "alice")
+	if _, err := q.Enqueue("bob", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := q.Enqueue("carol", ""); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("got %v, want %v", err, ErrQueueFull)
+	}
+	// rejected job isn't user's active one
+	q.mu.Lock()
+	_, active := q.byUser["carol"]
+	q.mu.Unlock()
+	if active {
+		t.Error("rejected job must not be active")
+	}
+
+	r.release <- nil
+	r.release <- nil
+}
+
+func TestJobStates(t *testing.T) {
+	r := newTestRun()
+	q := NewQueue(1, 10, r.run)
+	t.Cleanup(func() { q.Shutdown(context.Background()) })
+
+	job, err := q.Enqueue("alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.State != Queued || job.FinishedAt != nil {
+		t.Errorf("new job: state %q, finishedAt %v", job.State, job.FinishedAt)
+	}
+	r.waitStarted(t, "alice")
+	if job, _ := q.Get(job.ID); job.State != Deleting {
+		t.Errorf("running job: state %q, want %q", job.State, Deleting)
+	}
+
+	r.release <- nil
+	done := waitFinished(t, q, job.ID)
+	if done.State != Done || done.FinishedAt == nil || len(done.Error) != 0 {
+		t.Errorf("done job: %+v", done)
+	}
+
+	job, err = q.Enqueue("alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.waitStarted(t, "alice")
+	r.release <- errors.New("multiotp -delete: exit code 39")
+	failed := waitFinished(t, q, job.ID)
+	if failed.State != Failed || failed.Error != "multiotp -delete: exit code 39" {
+		t.Errorf("failed job: %+v", failed)
+	}
+
+	if _, ok := q.Get("unknown"); ok {
+		t.Error("unknown job must not be found")
+	}
+}
+
+// finished jobs are removed after finishedTTL on next enqueue
+func TestPrune(t *testing.T) {
+	r := newTestRun()
+	q := NewQueue(1, 10, r.run)
+	t.Cleanup(func() { q.Shutdown(context.Background()) })
+
+	old, err := q.Enqueue("alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.waitStarted(t, "alice")
+	r.release <- nil
+	waitFinished(t, q, old.ID)
+
+	recent, err := q.Enqueue("bob", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.waitStarted(t, "bob")
+	r.release <- nil
+	waitFinished(t, q, recent.ID)
+
+	q.mu.Lock()
+	finishedAt := time.Now().Add(-finishedTTL - time.Minute)
+	q.jobs[old.ID].FinishedAt = &finishedAt
+	q.mu.Unlock()
+
+	next, err := q.Enqueue("carol", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.waitStarted(t, "carol")
+	r.release <- nil
+
+	if _, ok := q.Get(old.ID); ok {
+		t.Error("old finished job must be pruned")
+	}
+	if _, ok := q.Get(recent.ID); !ok {
+		t.Error("recent finished job must be kept")
+	}
+	waitFinished(t, q, next.ID)
+}
+
+// running job is finished, queued one is failed
+func TestShutdown(t *testing.T) {
+	r := newTestRun()
+	q := NewQueue(1, 10, r.run)
+
+	running, err := q.Enqueue("alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.waitStarted(t, "alice")
+	queued, err := q.Enqueue("bob", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- q.Shutdown(context.Background()) }()
+	// running job is released after queue is stopping
+	for {
+		q.mu.Lock()
+		stopping := q.stopping
+		q.mu.Unlock()
+		if stopping {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	r.release <- nil
+	if err := <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+
+	if job, _ := q.Get(running.ID); job.State != Done {
+		t.Errorf("running job: state %q, want %q", job.State, Done)
+	}
+	if job, _ := q.Get(queued.ID); job.State != Failed || job.Error != ErrShuttingDown.Error() {
+		t.Errorf("queued job: %+v, want failed with %v", job, ErrShuttingDown)
+	}
+	if _, err := q.Enqueue("carol", ""); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("enqueue after shutdown: got %v, want %v", err, ErrShuttingDown)
+	}
+}
+
+// running job is cancelled when Shutdown's wait is over
+func TestShutdownTimeout(t *testing.T) {
+	r := newTestRun()
+	q := NewQueue(1, 10, r.run)
+
+	running, err := q.Enqueue("alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.waitStarted(t, "alice")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := q.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	job := waitFinished(t, q, running.ID)
+	if job.State != Failed || job.Error != context.Canceled.Error() {
+		t.Errorf("cancelled job: %+v", job)
+	}
+}
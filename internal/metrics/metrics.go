@@ -0,0 +1,139 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// all metrics names start with it
+const namespace = "otp_portal"
+
+// Login attempt outcomes
+const (
+	LoginValidationError  = "validation_error"
+	LoginLDAPConnectError = "ldap_connect_error"
+	LoginLDAPBindFailure  = "ldap_bind_failure"
+	LoginOTPFailure       = "otp_failure"
+	LoginSuccess          = "success"
+)
+
+// own registry: only portal, Go & process metrics
+var registry = prometheus.NewRegistry()
+
+var (
+	loginAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "login_attempts_total",
+		Help:      "Login attempts by outcome.",
+	}, []string{"outcome"})
+
+	ldapDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ldap_operation_duration_seconds",
+		Help:      "LDAP connect/bind/search latency per domain.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"domain", "operation", "result"})
+
+	multiOTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "multiotp_command_duration_seconds",
+		Help:      "MultiOTP CLI invocation duration by command and exit code.",
+		// full LDAP sync may take a minute or more
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"command", "exit_code"})
+
+	privacyIdeaDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "privacyidea_call_duration_seconds",
+		Help:      "PrivacyIdea API call latency.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"call", "result"})
+
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route and status code.",
+	}, []string{"route", "code"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request duration by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		loginAttempts,
+		ldapDuration,
+		multiOTPDuration,
+		privacyIdeaDuration,
+		httpRequests,
+		httpDuration,
+	)
+}
+
+// Handler serves /metrics
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// RegisterActiveSessions adds active sessions gauge, count is called on every scrape.
+// Count errors are skipped(gauge shows -1).
+func RegisterActiveSessions(count func() (int, error)) {
+	registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_sessions",
+		Help:      "Not expired sessions in session store.",
+	}, func() float64 {
+		n, err := count()
+		if err != nil {
+			return -1
+		}
+		return float64(n)
+	}))
+}
+
+// LoginAttempt counts login attempt with outcome(Login* consts)
+func LoginAttempt(outcome string) {
+	loginAttempts.WithLabelValues(outcome).Inc()
+}
+
+// ObserveLDAP records LDAP operation("connect", "bind", "search") started at start
+func ObserveLDAP(domain, operation string, start time.Time, err error) {
+	ldapDuration.WithLabelValues(domain, operation, result(err)).Observe(time.Since(start).Seconds())
+}
+
+// ObserveMultiOTP records MultiOTP command(ex. "-urllink") run and its exit code
+func ObserveMultiOTP(command string, exitCode int, duration time.Duration) {
+	multiOTPDuration.WithLabelValues(command, strconv.Itoa(exitCode)).Observe(duration.Seconds())
+}
+
+// ObservePrivacyIdea records PrivacyIdea API call started at start
+func ObservePrivacyIdea(call string, start time.Time, err error) {
+	privacyIdeaDuration.WithLabelValues(call, result(err)).Observe(time.Since(start).Seconds())
+}
+
+// ObserveHTTP records HTTP request, route is mux pattern(ex. "GET /qr/view")
+func ObserveHTTP(route string, code int, duration time.Duration) {
+	if len(route) == 0 {
+		route = "unmatched"
+	}
+	httpRequests.WithLabelValues(route, strconv.Itoa(code)).Inc()
+	httpDuration.WithLabelValues(route).Observe(duration.Seconds())
+}
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
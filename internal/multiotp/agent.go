@@ -0,0 +1,240 @@
+package multiotp
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+/*
+MultiOTP agent protocol(v1): JSON over HTTP(S), served by otp-portal-multiotp-agent
+on MultiOTP host, used by HTTPClient. Every request needs 'Authorization: Bearer <token>'.
+It isn't MultiOTP's web service(multiotp.server.php): MultiOTP client/server protocol
+has no token URL, delete, create or LDAP sync commands, so agent runs MultiOTP CLI.
+
+GET    /v1/users/{user}/token-url -> {"tokenURL": "otpauth://..."}
+GET    /v1/users/{user}           -> {"key": "value", ...}(user info)
+DELETE /v1/users/{user}           -> 204
+POST   /v1/users/{user}           <- TokenProfile(optional) -> 204(create with new token)
+PATCH  /v1/users/{user}           <- {"key": "value", ...} -> 204(set attributes)
+POST   /v1/users/{user}/resync    <- {"otp1": "...", "otp2": "..."} -> 204
+POST   /v1/users/{user}/check     <- {"otp": "..."} -> 204(OTP accepted)
+POST   /v1/users/{user}/unlock    -> 204
+POST   /v1/sync                   -> 204(-ldap-users-sync)
+GET    /v1/capabilities           -> Capabilities
+GET    /v1/check                  -> 204
+
+Errors: {"error": "...", "command": "-delete", "exitCode": 21},
+command & exitCode are set for MultiOTP exit codes(see ExitError).
+*/
+
+// agent error response
+type agentError struct {
+	Error    string `json:"error"`
+	Command  string `json:"command,omitempty"`
+	ExitCode int    `json:"exitCode,omitempty"`
+}
+
+// agent token URL response
+type agentTokenURL struct {
+	TokenURL string `json:"tokenURL"`
+}
+
+// agent OTP check request
+type agentCheckOTP struct {
+	OTP string `json:"otp"`
+}
+
+// agent resync request
+type agentResync struct {
+	OTP1 string `json:"otp1"`
+	OTP2 string `json:"otp2"`
+}
+
+type agent struct {
+	client Client
+	token  string
+	logger *slog.Logger
+}
+
+// NewAgentHandler serves MultiOTP agent protocol for client(usually CLI),
+// requests must have bearer token
+func NewAgentHandler(client Client, token string, logger *slog.Logger) http.Handler {
+	a := &agent{client: client, token: token, logger: logger}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/users/{user}/token-url", a.tokenURL)
+	mux.HandleFunc("GET /v1/users/{user}", a.userInfo)
+	mux.HandleFunc("DELETE /v1/users/{user}", a.deleteUser)
+	mux.HandleFunc("POST /v1/users/{user}", a.createUser)
+	mux.HandleFunc("PATCH /v1/users/{user}", a.setUserAttributes)
+	mux.HandleFunc("POST /v1/users/{user}/resync", a.resync)
+	mux.HandleFunc("POST /v1/users/{user}/check", a.checkOTP)
+	mux.HandleFunc("POST /v1/users/{user}/unlock", a.unlockUser)
+	mux.HandleFunc("POST /v1/sync", a.syncUsers)
+	mux.HandleFunc("GET /v1/capabilities", a.capabilities)
+	mux.HandleFunc("GET /v1/check", a.check)
+
+	return a.authenticate(mux)
+}
+
+// check bearer token(constant time compare)
+func (a *agent) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			a.logger.Warn("agent request unauthorized", "remoteAddr", r.RemoteAddr, "uri", r.URL.RequestURI())
+			a.writeJSON(w, http.StatusUnauthorized, agentError{Error: "unauthorized"})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *agent) tokenURL(w http.ResponseWriter, r *http.Request) {
+	tokenURL, err := a.client.GetTokenURL(r.Context(), r.PathValue("user"))
+	if err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+
+	a.writeJSON(w, http.StatusOK, agentTokenURL{TokenURL: tokenURL})
+}
+
+func (a *agent) userInfo(w http.ResponseWriter, r *http.Request) {
+	info, err := a.client.UserInfo(r.Context(), r.PathValue("user"))
+	if err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+
+	a.writeJSON(w, http.StatusOK, info)
+}
+
+func (a *agent) deleteUser(w http.ResponseWriter, r *http.Request) {
+	a.writeResult(w, r, a.client.DeleteUser(r.Context(), r.PathValue("user")))
+}
+
+// create with default token or by profile in body
+func (a *agent) createUser(w http.ResponseWriter, r *http.Request) {
+	var profile TokenProfile
+	err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&profile)
+	switch {
+	case errors.Is(err, io.EOF):
+		a.writeResult(w, r, a.client.CreateUser(r.Context(), r.PathValue("user")))
+		return
+	case err == nil:
+		err = profile.Validate()
+	}
+	if err != nil {
+		a.writeJSON(w, http.StatusBadRequest, agentError{Error: "bad request: " + err.Error()})
+		return
+	}
+
+	a.writeResult(w, r, a.client.CreateUserProfile(r.Context(), r.PathValue("user"), profile))
+}
+
+func (a *agent) setUserAttributes(w http.ResponseWriter, r *http.Request) {
+	var attrs UserInfo
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&attrs); err != nil {
+		a.writeJSON(w, http.StatusBadRequest, agentError{Error: "bad request: " + err.Error()})
+		return
+	}
+	if _, err := attrs.setArgs(r.PathValue("user")); err != nil {
+		a.writeJSON(w, http.StatusBadRequest, agentError{Error: "bad request: " + err.Error()})
+		return
+	}
+
+	a.writeResult(w, r, a.client.SetUserAttributes(r.Context(), r.PathValue("user"), attrs))
+}
+
+func (a *agent) resync(w http.ResponseWriter, r *http.Request) {
+	var req agentResync
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&req); err != nil {
+		a.writeJSON(w, http.StatusBadRequest, agentError{Error: "bad request: " + err.Error()})
+		return
+	}
+
+	a.writeResult(w, r, a.client.Resync(r.Context(), r.PathValue("user"), req.OTP1, req.OTP2))
+}
+
+func (a *agent) checkOTP(w http.ResponseWriter, r *http.Request) {
+	var req agentCheckOTP
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&req); err != nil {
+		a.writeJSON(w, http.StatusBadRequest, agentError{Error: "bad request: " + err.Error()})
+		return
+	}
+
+	a.writeResult(w, r, a.client.CheckOTP(r.Context(), r.PathValue("user"), req.OTP))
+}
+
+func (a *agent) unlockUser(w http.ResponseWriter, r *http.Request) {
+	a.writeResult(w, r, a.client.UnlockUser(r.Context(), r.PathValue("user")))
+}
+
+func (a *agent) syncUsers(w http.ResponseWriter, r *http.Request) {
+	a.writeResult(w, r, a.client.SyncUsers(r.Context()))
+}
+
+func (a *agent) capabilities(w http.ResponseWriter, r *http.Request) {
+	caps, err := a.client.Capabilities(r.Context())
+	if err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+
+	a.writeJSON(w, http.StatusOK, caps)
+}
+
+func (a *agent) check(w http.ResponseWriter, r *http.Request) {
+	a.writeResult(w, r, a.client.Check(r.Context()))
+}
+
+// 204 or error
+func (a *agent) writeResult(w http.ResponseWriter, r *http.Request, err error) {
+	if err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// write error with status by its type, MultiOTP exit code is passed to client
+func (a *agent) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	resp := agentError{Error: err.Error()}
+	status := http.StatusInternalServerError
+
+	var exitErr *ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		resp.Command = exitErr.Command
+		resp.ExitCode = exitErr.Code
+		status = http.StatusBadGateway
+		switch {
+		case errors.Is(err, ErrUserNotFound), errors.Is(err, ErrTokenNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, ErrUserExists):
+			status = http.StatusConflict
+		}
+	case errors.Is(err, context.DeadlineExceeded):
+		status = http.StatusGatewayTimeout
+	}
+
+	a.logger.Warn("agent request failed", "method", r.Method, "uri", r.URL.RequestURI(), "status", status, slog.Any("error", err))
+	a.writeJSON(w, status, resp)
+}
+
+func (a *agent) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		a.logger.Error("failed to write agent response", slog.Any("error", err))
+	}
+}
@@ -0,0 +1,309 @@
+package multiotp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/slayerjk/go-multiotp-ldap-users-web-portal/internal/metrics"
+)
+
+// after ctx is done and process tree is killed, wait that long for
+// output pipes to close(they may be held by orphaned grandchildren)
+const waitDelay = 5 * time.Second
+
+// CLI is Client running local MultiOTP binary
+type CLI struct {
+	binPath string
+	opts    Options
+	logger  *slog.Logger
+}
+
+var _ Client = (*CLI)(nil)
+
+// NewCLI returns Client for MultiOTP binary.
+// Reissue strategy is resolved by binary's capabilities,
+// error means the strategy isn't supported.
+func NewCLI(ctx context.Context, multiOTPBinPath string, opts Options) (*CLI, error) {
+	c := &CLI{binPath: multiOTPBinPath, opts: opts, logger: opts.Logger}
+	if c.logger == nil {
+		c.logger = slog.New(slog.DiscardHandler)
+	}
+
+	strategy, err := resolveStrategy(ctx, c, opts, c.logger)
+	if err != nil {
+		return nil, err
+	}
+	c.opts.Strategy = strategy
+
+	return c, nil
+}
+
+// IsPHPScript returns true if MultiOTP binary path is PHP script(needs PHP interpreter)
+func IsPHPScript(multiOTPBinPath string) bool {
+	return strings.EqualFold(filepath.Ext(multiOTPBinPath), ".php")
+}
+
+// normalizes '-user-info' keys
+var userInfoKeyReplacer = strings.NewReplacer(" ", "_", "-", "_")
+
+// otpauth URL's secret, hidden in logs
+var secretPattern = regexp.MustCompile(`(?i)(secret=)[^&\s"']+`)
+
+// RedactOutput hides token secrets in MultiOTP output
+func RedactOutput(out []byte) string {
+	return secretPattern.ReplaceAllString(string(out), "${1}[REDACTED]")
+}
+
+// Make MultiOTP command killing its whole process tree when ctx is done
+// (multiotp.exe may run php, which outlives killed parent).
+// multiotp.php is run by PHP interpreter(Linux), other binaries/scripts directly.
+func (c *CLI) command(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, c.binPath, args...)
+	if IsPHPScript(c.binPath) {
+		cmd = exec.CommandContext(ctx, c.opts.PHPPath, append([]string{c.binPath}, args...)...)
+	}
+	killProcessTree(cmd)
+	cmd.WaitDelay = waitDelay
+
+	return cmd
+}
+
+// Run MultiOTP command with timeout expecting one of success exit codes.
+// Due to multiotp console tools throw Exit codes every time,
+// any other exit code is *ExitError.
+// Every run is recorded in metrics(duration & exit code, -1 if command
+// failed to start or was killed) and logged with redacted stdout/stderr.
+func (c *CLI) run(ctx context.Context, timeout time.Duration, success []int, args ...string) ([]byte, error) {
+	operations.Add(1)
+	defer operations.Done()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := c.command(ctx, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	command := commandName(args)
+	start := time.Now()
+	err := cmd.Run()
+	duration := time.Since(start)
+	// ExitCode is -1 if process hasn't started(ProcessState is nil) or was killed
+	exitCode := cmd.ProcessState.ExitCode()
+	metrics.ObserveMultiOTP(command, exitCode, duration)
+
+	switch _, exited := err.(*exec.ExitError); {
+	case ctx.Err() != nil:
+		// killed by timeout or cancelled request/job
+		err = fmt.Errorf("multiotp %s: %w", command, ctx.Err())
+	case exited || err == nil:
+		err = nil
+		if !slices.Contains(success, exitCode) {
+			err = &ExitError{Command: command, Code: exitCode}
+		}
+	}
+
+	level := slog.LevelDebug
+	if err != nil {
+		level = slog.LevelWarn
+	}
+	c.logger.Log(context.Background(), level, "multiotp command finished",
+		"command", command,
+		"exitCode", exitCode,
+		"duration", duration,
+		"stdout", RedactOutput(stdout.Bytes()),
+		"stderr", RedactOutput(stderr.Bytes()),
+		slog.Any("error", err),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// Command name for logs & metrics: OTP check('multiotp user otp') has no
+// command option, its args(user & OTP) must not get there
+func commandName(args []string) string {
+	if !strings.HasPrefix(args[0], "-") {
+		return "check"
+	}
+	return args[0]
+}
+
+// UnlockUser unlocks user's token('-unlock user')
+func (c *CLI) UnlockUser(ctx context.Context, user string) error {
+	// 19 INFO: Requested operation successfully done
+	_, err := c.run(ctx, c.opts.Timeout, []int{19}, "-unlock", user)
+	return err
+}
+
+// CheckOTP checks user's OTP('multiotp user otp')
+func (c *CLI) CheckOTP(ctx context.Context, user, otp string) error {
+	// 0 OK: Token accepted
+	_, err := c.run(ctx, c.opts.Timeout, []int{0}, user, otp)
+	return err
+}
+
+// GetTokenURL returns user's totpURL('-urllink user')
+func (c *CLI) GetTokenURL(ctx context.Context, user string) (string, error) {
+	// 17 INFO: UrlLink successfully created
+	out, err := c.run(ctx, c.opts.Timeout, []int{17}, "-urllink", user)
+	if err != nil {
+		return "", err
+	}
+
+	// check output is what expected: URL may be followed by info line
+	// and lines end with "\r\n" on Windows, "\n" on Linux
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "otpauth://") {
+			return line, nil
+		}
+	}
+	return "", fmt.Errorf("mutliotp command doesn't match '^otpauth://', output:\n\t\t%s", RedactOutput(out))
+}
+
+// DeleteUser deletes user('-delete user')
+func (c *CLI) DeleteUser(ctx context.Context, user string) error {
+	// 12 INFO: User successfully deleted
+	// OR
+	// 19 INFO: Requested operation successfully done
+	_, err := c.run(ctx, c.opts.Timeout, []int{12, 19}, "-delete", user)
+	return err
+}
+
+// CreateUser creates user with new random TOTP token and no PIN('-fastcreatenopin user')
+func (c *CLI) CreateUser(ctx context.Context, user string) error {
+	// 11 INFO: User successfully created or updated
+	_, err := c.run(ctx, c.opts.Timeout, []int{11}, "-fastcreatenopin", user)
+	return err
+}
+
+// Resync resynchronizes user's token('-resync user otp1 otp2'),
+// OTPs aren't logged(only command name is)
+func (c *CLI) Resync(ctx context.Context, user, otp1, otp2 string) error {
+	// 14 INFO: Token has been resynchronized successfully
+	_, err := c.run(ctx, c.opts.Timeout, []int{14}, "-resync", user, otp1, otp2)
+	return err
+}
+
+// CreateUserProfile creates user with new random token by profile
+// ('-create -no-prefix-pin user TYPE seed pin digits period'), then non-SHA1
+// algorithm is set('-set user token_algo_suffix=ALGORITHM').
+// Seed & PIN aren't logged(only command name is).
+func (c *CLI) CreateUserProfile(ctx context.Context, user string, profile TokenProfile) error {
+	// 11 INFO: User successfully created or updated
+	_, err := c.run(ctx, c.opts.Timeout, []int{11}, profile.createArgs(user)...)
+	if err != nil || strings.EqualFold(profile.Algorithm, "SHA1") {
+		return err
+	}
+
+	// 11 INFO: User successfully created or updated
+	_, err = c.run(ctx, c.opts.Timeout, []int{11}, "-set", user, "token_algo_suffix="+strings.ToUpper(profile.Algorithm))
+	return err
+}
+
+// SetUserAttributes sets user's attributes('-set user key=value...'),
+// only RestoredAttributes keys are allowed
+func (c *CLI) SetUserAttributes(ctx context.Context, user string, attrs UserInfo) error {
+	args, err := attrs.setArgs(user)
+	if err != nil {
+		return err
+	}
+
+	// 11 INFO: User successfully created or updated
+	_, err = c.run(ctx, c.opts.Timeout, []int{11}, args...)
+	return err
+}
+
+// SyncUsers runs '-ldap-users-sync' via process-wide coordinator(see sync.go)
+// and waits for its result or ctx to be done.
+// Concurrent calls never run concurrent syncs.
+func (c *CLI) SyncUsers(ctx context.Context) error {
+	return usersSync.sync(ctx, c.resyncUsers)
+}
+
+// Resync MultiOTP Users, called by sync coordinator only.
+// Sync is shared by several callers, so it isn't bound to any caller's ctx,
+// only to SyncTimeout.
+func (c *CLI) resyncUsers(ctx context.Context) error {
+	// 19 INFO: Requested operation successfully done
+	_, err := c.run(ctx, c.opts.SyncTimeout, []int{19}, "-ldap-users-sync")
+	return err
+}
+
+// UserInfo returns user's attributes('-user-info user'),
+// output lines "key: value" are parsed, key is normalized(see parseUserInfo)
+func (c *CLI) UserInfo(ctx context.Context, user string) (UserInfo, error) {
+	// 19 INFO: Requested operation successfully done
+	out, err := c.run(ctx, c.opts.Timeout, []int{19}, "-user-info", user)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseUserInfo(out), nil
+}
+
+// parse "key: value" lines of '-user-info' output,
+// key is lower-cased, spaces & dashes are replaced by "_"(ex. "Creation date" -> "creation_date")
+func parseUserInfo(out []byte) UserInfo {
+	info := make(UserInfo)
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		key = userInfoKeyReplacer.Replace(strings.ToLower(strings.TrimSpace(key)))
+		if !ok || len(key) == 0 {
+			continue
+		}
+		info[key] = strings.TrimSpace(value)
+	}
+
+	return info
+}
+
+// Reissue MultiOTP QR with CLI's strategy, by profile if it isn't nil.
+// onStep(may be nil) is called before every step(StepDeleting, then StepSyncing or StepCreating).
+func (c *CLI) Reissue(ctx context.Context, user string, profile *TokenProfile, onStep func(step string)) error {
+	return reissue(ctx, c, c.opts.Strategy, user, profile, onStep)
+}
+
+// Capabilities of MultiOTP binary: 'multiotp -help' output is checked
+// for supported commands. Any exit code is ok(multiotp always exits with info/error code).
+func (c *CLI) Capabilities(ctx context.Context) (Capabilities, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+	defer cancel()
+
+	out, err := c.command(ctx, "-help").Output()
+	if ctx.Err() != nil {
+		return Capabilities{}, ctx.Err()
+	}
+	if _, ok := err.(*exec.ExitError); !ok && err != nil {
+		return Capabilities{}, err
+	}
+
+	return parseCapabilities(string(out)), nil
+}
+
+// Check MultiOTP binary can be executed: run 'multiotp -version'.
+// Any exit code is ok(multiotp always exits with info/error code),
+// only failure to start is error.
+func (c *CLI) Check(ctx context.Context) error {
+	err := c.command(ctx, "-version").Run()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if _, ok := err.(*exec.ExitError); ok {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,239 @@
+//go:build !windows
+
+package multiotp
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// CLI running fake MultiOTP script(testdata/multiotp) with state in temp dir
+func newTestCLI(t *testing.T, opts Options) (*CLI, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	t.Setenv("FAKE_MULTIOTP_DIR", dir)
+	t.Setenv("FAKE_MULTIOTP_DELAY", "")
+	t.Setenv("FAKE_MULTIOTP_RESYNC_FAIL", "")
+
+	if opts.Timeout == 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.SyncTimeout == 0 {
+		opts.SyncTimeout = 10 * time.Second
+	}
+	if len(opts.Strategy) == 0 {
+		opts.Strategy = StrategyAuto
+	}
+
+	binPath, err := filepath.Abs(filepath.Join("testdata", "multiotp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewCLI(context.Background(), binPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c, dir
+}
+
+// set users returned by fake LDAP
+func setLDAPUsers(t *testing.T, dir string, users ...string) {
+	t.Helper()
+
+	err := os.WriteFile(filepath.Join(dir, "ldap-users"), []byte(strings.Join(users, "\n")+"\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCLIFlow(t *testing.T) {
+	c, dir := newTestCLI(t, Options{Strategy: StrategyAuto})
+	ctx := context.Background()
+
+	// fake supports -fastcreatenopin
+	if c.opts.Strategy != StrategyRecreate {
+		t.Errorf("auto strategy resolved to %q, want %q", c.opts.Strategy, StrategyRecreate)
+	}
+
+	setLDAPUsers(t, dir, "alice")
+	if err := c.SyncUsers(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	tokenURL, err := c.GetTokenURL(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(tokenURL, "otpauth://totp/multiOTP:alice?secret=") {
+		t.Errorf("unexpected token URL %q", tokenURL)
+	}
+
+	info, err := c.UserInfo(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info["locked"] != "0" || info["out_of_sync"] != "0" {
+		t.Errorf("unexpected user info %v", info)
+	}
+
+	var steps []string
+	err = c.Reissue(ctx, "alice", nil, func(step string) { steps = append(steps, step) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(steps, ",") != StepDeleting+","+StepCreating {
+		t.Errorf("reissue steps %q", steps)
+	}
+	newURL, err := c.GetTokenURL(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newURL == tokenURL {
+		t.Error("reissue must generate new secret")
+	}
+
+	if err := c.CheckOTP(ctx, "alice", "123456"); err != nil {
+		t.Errorf("valid OTP: %v", err)
+	}
+	if err := c.Resync(ctx, "alice", "111111", "222222"); err != nil {
+		t.Errorf("resync: %v", err)
+	}
+}
+
+func TestCLIReissueProfile(t *testing.T) {
+	c, dir := newTestCLI(t, Options{Strategy: StrategyFullSync, Profiles: true})
+	ctx := context.Background()
+
+	setLDAPUsers(t, dir, "alice")
+	if err := c.SyncUsers(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	profile := TokenProfile{Name: "compliance", Type: TokenTOTP, Algorithm: "SHA256", Digits: 8, Period: 60}
+	if err := c.Reissue(ctx, "alice", &profile, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tokenURL, err := c.GetTokenURL(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(tokenURL, "&algorithm=SHA256&digits=8&period=60") {
+		t.Errorf("token URL %q doesn't match profile", tokenURL)
+	}
+}
+
+// user's LDAP attributes survive reissue with any strategy and by profile
+func TestCLIReissueKeepsAttributes(t *testing.T) {
+	want := UserInfo{"description": "Alice Smith", "email": "alice@example.com", "group": "staff"}
+
+	tests := []struct {
+		strategy string
+		profile  *TokenProfile
+	}{
+		{StrategyFullSync, nil},
+		{StrategyRecreate, nil},
+		{StrategyRecreate, &testProfile},
+	}
+
+	for _, tt := range tests {
+		c, dir := newTestCLI(t, Options{Strategy: tt.strategy, Profiles: true})
+		ctx := context.Background()
+
+		setLDAPUsers(t, dir, "alice;Alice Smith;alice@example.com;staff")
+		if err := c.SyncUsers(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Reissue(ctx, "alice", tt.profile, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := c.UserInfo(ctx, "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.restored(); !maps.Equal(got, want) {
+			t.Errorf("%s(profile %v): attributes %v, want %v", tt.strategy, tt.profile, got, want)
+		}
+	}
+}
+
+func TestCLIExitCodes(t *testing.T) {
+	c, dir := newTestCLI(t, Options{})
+	ctx := context.Background()
+
+	setLDAPUsers(t, dir, "alice")
+	if err := c.SyncUsers(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// 21 ERROR: User doesn't exist
+	_, err := c.GetTokenURL(ctx, "bob")
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 21 || !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("unknown user: got %v, want exit code 21(ErrUserNotFound)", err)
+	}
+	if err := c.DeleteUser(ctx, "bob"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("delete unknown user: got %v, want ErrUserNotFound", err)
+	}
+
+	// 27 ERROR: Resynchronization of the token has failed
+	if err := c.Resync(ctx, "alice", "111111", "111111"); !errors.Is(err, ErrResyncFailed) {
+		t.Errorf("resync: got %v, want ErrResyncFailed", err)
+	}
+	t.Setenv("FAKE_MULTIOTP_RESYNC_FAIL", "1")
+	if err := c.Resync(ctx, "alice", "111111", "222222"); !errors.Is(err, ErrResyncFailed) {
+		t.Errorf("resync: got %v, want ErrResyncFailed", err)
+	}
+
+	// 99 ERROR: Authentication failed, token is locked after 3 failures
+	for range 3 {
+		if err := c.CheckOTP(ctx, "alice", "000000"); !errors.Is(err, ErrAuthFailed) {
+			t.Errorf("wrong OTP: got %v, want ErrAuthFailed", err)
+		}
+	}
+
+	// 24 ERROR: Token locked
+	err = c.CheckOTP(ctx, "alice", "123456")
+	if !errors.As(err, &exitErr) || exitErr.Code != 24 || !errors.Is(err, ErrTokenLocked) {
+		t.Errorf("locked token: got %v, want exit code 24(ErrTokenLocked)", err)
+	}
+
+	if err := c.UnlockUser(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CheckOTP(ctx, "alice", "123456"); err != nil {
+		t.Errorf("unlocked token: %v", err)
+	}
+}
+
+// hung command is killed by timeout with its whole process tree:
+// fake script's 'sleep' holds output pipe, so without tree kill
+// run would wait for waitDelay
+func TestCLITimeout(t *testing.T) {
+	c, dir := newTestCLI(t, Options{Timeout: 200 * time.Millisecond})
+	ctx := context.Background()
+
+	setLDAPUsers(t, dir, "alice")
+	if err := c.SyncUsers(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("FAKE_MULTIOTP_DELAY", "30")
+	start := time.Now()
+	_, err := c.GetTokenURL(ctx, "alice")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed >= waitDelay/2 {
+		t.Errorf("command killed in %s, process tree wasn't killed", elapsed)
+	}
+}
@@ -0,0 +1,119 @@
+package multiotp
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Typed MultiOTP errors, use errors.Is to check them
+var (
+	ErrUserNotFound     = errors.New("user doesn't exist")
+	ErrUserExists       = errors.New("user already exists")
+	ErrUserDisabled     = errors.New("user is deactivated")
+	ErrTokenNotFound    = errors.New("token doesn't exist")
+	ErrTokenLocked      = errors.New("token is locked")
+	ErrTokenDelayed     = errors.New("token is delayed")
+	ErrTokenUsed        = errors.New("token has already been used")
+	ErrResyncFailed     = errors.New("token resync failed")
+	ErrAuthFailed       = errors.New("authentication failed")
+	ErrMissingParameter = errors.New("parameter is missing")
+	ErrStorage          = errors.New("multiotp storage error")
+	ErrServer           = errors.New("multiotp server error")
+	ErrNotCreated       = errors.New("requested item not created")
+	ErrOperationAborted = errors.New("operation aborted")
+	ErrInvalidAlgorithm = errors.New("invalid algorithm")
+	ErrTokenAttributed  = errors.New("token already attributed")
+	ErrTokensImport     = errors.New("tokens definition file import failed")
+	ErrSMS              = errors.New("sms code error")
+	ErrEmail            = errors.New("failed to send email")
+	ErrHA               = errors.New("high availability mode error")
+)
+
+// MultiOTP exit code: its documented message and typed error(nil for OK/INFO codes only)
+type exitCodeInfo struct {
+	message string
+	err     error
+}
+
+// Documented MultiOTP exit codes.
+// MultiOTP always exits with one of them, so success is checked
+// by the code expected for the command(ex. 17 for '-urllink').
+var exitCodes = map[int]exitCodeInfo{
+	0:  {"OK: Token accepted", nil},
+	7:  {"INFO: User requires a token", nil},
+	8:  {"INFO: The user is a pending tokens user", nil},
+	10: {"INFO: Access Challenge returned back to the client", nil},
+	11: {"INFO: User successfully created or updated", nil},
+	12: {"INFO: User successfully deleted", nil},
+	13: {"INFO: User PIN code successfully changed", nil},
+	14: {"INFO: Token has been resynchronized successfully", nil},
+	15: {"INFO: Tokens definition file successfully imported", nil},
+	16: {"INFO: QRcode successfully created", nil},
+	17: {"INFO: UrlLink successfully created", nil},
+	18: {"INFO: SMS code request received", nil},
+	19: {"INFO: Requested operation successfully done", nil},
+	21: {"ERROR: User doesn't exist", ErrUserNotFound},
+	22: {"ERROR: User already exists", ErrUserExists},
+	23: {"ERROR: Invalid algorithm", ErrInvalidAlgorithm},
+	24: {"ERROR: Token locked", ErrTokenLocked},
+	25: {"ERROR: Token delayed", ErrTokenDelayed},
+	26: {"ERROR: This token has already been used", ErrTokenUsed},
+	27: {"ERROR: Resynchronization of the token has failed", ErrResyncFailed},
+	28: {"ERROR: Unable to write the changes in the file", ErrStorage},
+	29: {"ERROR: Token doesn't exist", ErrTokenNotFound},
+	30: {"ERROR: At least one parameter is missing", ErrMissingParameter},
+	31: {"ERROR: Tokens definition file doesn't exist", ErrTokensImport},
+	32: {"ERROR: Tokens definition file not successfully imported", ErrTokensImport},
+	33: {"ERROR: Encryption hash error, encryption key is not the same", ErrStorage},
+	34: {"ERROR: Linked user doesn't exist", ErrUserNotFound},
+	35: {"ERROR: User not created", ErrNotCreated},
+	37: {"ERROR: Token already attributed", ErrTokenAttributed},
+	38: {"ERROR: User is desactivated", ErrUserDisabled},
+	39: {"ERROR: Requested operation aborted", ErrOperationAborted},
+	40: {"ERROR: SQL query error", ErrStorage},
+	41: {"ERROR: SQL error", ErrStorage},
+	42: {"ERROR: They key is not in the table schema", ErrStorage},
+	43: {"ERROR: SQL entry cannot be updated", ErrStorage},
+	50: {"ERROR: QRcode not created", ErrNotCreated},
+	51: {"ERROR: UrlLink not created (no provisionable client for this protocol)", ErrNotCreated},
+	60: {"ERROR: No information on where to send SMS code", ErrSMS},
+	61: {"ERROR: SMS code request received, but an error occurred during transmission", ErrSMS},
+	62: {"ERROR: SMS provider not supported", ErrSMS},
+	63: {"ERROR: This SMS code has expired", ErrSMS},
+	64: {"ERROR: Cannot resent an SMS code right now", ErrSMS},
+	69: {"ERROR: Failed to send email", ErrEmail},
+	70: {"ERROR: Server authentication error", ErrServer},
+	71: {"ERROR: Server request is not correctly formatted", ErrServer},
+	72: {"ERROR: Server answer is not correctly formatted", ErrServer},
+	80: {"ERROR: Server cache error", ErrServer},
+	81: {"ERROR: Cache too old for this user, account autolocked", ErrServer},
+	82: {"ERROR: Server timeout", ErrServer},
+	88: {"ERROR: Device is not defined as a HA slave", ErrHA},
+	89: {"ERROR: Device is not defined as a HA master", ErrHA},
+	93: {"ERROR: Authentication failed (time based token probably out of sync)", ErrAuthFailed},
+	94: {"ERROR: API request error", ErrServer},
+	95: {"ERROR: API authentication failed", ErrServer},
+	96: {"ERROR: Authentication failed (CHAP)", ErrAuthFailed},
+	97: {"ERROR: Authentication failed (wrong private id)", ErrAuthFailed},
+	98: {"ERROR: Authentication failed (wrong token length)", ErrAuthFailed},
+	99: {"ERROR: Authentication failed (and other possible unknown errors)", ErrAuthFailed},
+}
+
+// ExitError is unexpected exit code of MultiOTP command.
+// It unwraps to typed error(ex. ErrUserNotFound) if the code has one.
+type ExitError struct {
+	Command string
+	Code    int
+}
+
+func (e *ExitError) Error() string {
+	info, ok := exitCodes[e.Code]
+	if !ok {
+		return fmt.Sprintf("multiotp %s: unknown exit code %d", e.Command, e.Code)
+	}
+	return fmt.Sprintf("multiotp %s: exit code %d: %s", e.Command, e.Code, info.message)
+}
+
+func (e *ExitError) Unwrap() error {
+	return exitCodes[e.Code].err
+}
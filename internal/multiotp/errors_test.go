@@ -0,0 +1,35 @@
+package multiotp
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// every ERROR exit code unwraps to typed error, OK/INFO codes don't
+func TestExitCodes(t *testing.T) {
+	for code, info := range exitCodes {
+		err := &ExitError{Command: "-test", Code: code}
+
+		if !strings.HasPrefix(info.message, "ERROR:") {
+			if info.err != nil {
+				t.Errorf("%d %q: non-error code has typed error %v", code, info.message, info.err)
+			}
+			continue
+		}
+		if info.err == nil {
+			t.Errorf("%d %q: no typed error", code, info.message)
+			continue
+		}
+		if !errors.Is(err, info.err) {
+			t.Errorf("%d %q: errors.Is(%v) is false", code, info.message, info.err)
+		}
+		if !strings.Contains(err.Error(), info.message) {
+			t.Errorf("%d: error %q doesn't contain %q", code, err, info.message)
+		}
+	}
+
+	if err := (&ExitError{Command: "-test", Code: 123}); err.Unwrap() != nil || !strings.Contains(err.Error(), "unknown exit code 123") {
+		t.Errorf("unknown code: got %v, unwraps to %v", err, err.Unwrap())
+	}
+}
@@ -0,0 +1,298 @@
+package multiotp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"maps"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Fake is in-memory Client for handler tests, no MultiOTP is needed.
+// By default it keeps users and their token URLs in memory:
+// DeleteUser/GetTokenURL return ErrUserNotFound for unknown users,
+// CreateUser & Reissue generate new random secret.
+// Set *Func fields to script behaviour(errors, delays, etc.),
+// they replace default logic of their method.
+// Func fields must be set before Fake is used.
+type Fake struct {
+	GetTokenURLFunc       func(ctx context.Context, user string) (string, error)
+	UserInfoFunc          func(ctx context.Context, user string) (UserInfo, error)
+	DeleteUserFunc        func(ctx context.Context, user string) error
+	CreateUserFunc        func(ctx context.Context, user string) error
+	CreateUserProfileFunc func(ctx context.Context, user string, profile TokenProfile) error
+	SetUserAttributesFunc func(ctx context.Context, user string, attrs UserInfo) error
+	SyncUsersFunc         func(ctx context.Context) error
+	ResyncFunc            func(ctx context.Context, user, otp1, otp2 string) error
+	CheckOTPFunc          func(ctx context.Context, user, otp string) error
+	UnlockUserFunc        func(ctx context.Context, user string) error
+	ReissueFunc           func(ctx context.Context, user string, profile *TokenProfile, onStep func(step string)) error
+	CapabilitiesFunc      func(ctx context.Context) (Capabilities, error)
+	CheckFunc             func(ctx context.Context) error
+
+	mu    sync.Mutex
+	users map[string]string
+	attrs map[string]UserInfo
+	calls []string
+}
+
+var _ Client = (*Fake)(nil)
+
+// NewFake returns Fake with given users, each one gets random token
+func NewFake(users ...string) *Fake {
+	f := &Fake{users: make(map[string]string), attrs: make(map[string]UserInfo)}
+	for _, user := range users {
+		f.users[user] = fakeTokenURL(user)
+	}
+
+	return f
+}
+
+// SetTokenURL sets user's token URL(user is created if needed)
+func (f *Fake) SetTokenURL(user, tokenURL string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.users[user] = tokenURL
+}
+
+// Calls returns called methods with args, ex. "Reissue alice"
+func (f *Fake) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string(nil), f.calls...)
+}
+
+func (f *Fake) record(call ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, strings.Join(call, " "))
+}
+
+func (f *Fake) GetTokenURL(ctx context.Context, user string) (string, error) {
+	f.record("GetTokenURL", user)
+	if f.GetTokenURLFunc != nil {
+		return f.GetTokenURLFunc(ctx, user)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tokenURL, ok := f.users[user]
+	if !ok {
+		return "", &ExitError{Command: "-urllink", Code: 21}
+	}
+	return tokenURL, nil
+}
+
+func (f *Fake) UserInfo(ctx context.Context, user string) (UserInfo, error) {
+	f.record("UserInfo", user)
+	if f.UserInfoFunc != nil {
+		return f.UserInfoFunc(ctx, user)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.users[user]; !ok {
+		return nil, &ExitError{Command: "-user-info", Code: 21}
+	}
+	info := UserInfo{"user": user, "locked": "0", "out_of_sync": "0"}
+	maps.Copy(info, f.attrs[user])
+	return info, nil
+}
+
+func (f *Fake) DeleteUser(ctx context.Context, user string) error {
+	f.record("DeleteUser", user)
+	if f.DeleteUserFunc != nil {
+		return f.DeleteUserFunc(ctx, user)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.users[user]; !ok {
+		return &ExitError{Command: "-delete", Code: 21}
+	}
+	delete(f.users, user)
+	delete(f.attrs, user)
+	return nil
+}
+
+func (f *Fake) CreateUser(ctx context.Context, user string) error {
+	f.record("CreateUser", user)
+	if f.CreateUserFunc != nil {
+		return f.CreateUserFunc(ctx, user)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.users[user]; ok {
+		return &ExitError{Command: "-fastcreatenopin", Code: 22}
+	}
+	f.users[user] = fakeTokenURL(user)
+	return nil
+}
+
+func (f *Fake) CreateUserProfile(ctx context.Context, user string, profile TokenProfile) error {
+	f.record("CreateUserProfile", user, profile.Name)
+	if f.CreateUserProfileFunc != nil {
+		return f.CreateUserProfileFunc(ctx, user, profile)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.users[user]; ok {
+		return &ExitError{Command: "-create", Code: 22}
+	}
+	f.users[user] = fakeProfileTokenURL(user, profile)
+	return nil
+}
+
+// SetUserAttributes keeps attributes of existing users, UserInfo returns them
+func (f *Fake) SetUserAttributes(ctx context.Context, user string, attrs UserInfo) error {
+	f.record("SetUserAttributes", user)
+	if f.SetUserAttributesFunc != nil {
+		return f.SetUserAttributesFunc(ctx, user, attrs)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.users[user]; !ok {
+		return &ExitError{Command: "-set", Code: 21}
+	}
+	if f.attrs[user] == nil {
+		f.attrs[user] = make(UserInfo)
+	}
+	maps.Copy(f.attrs[user], attrs)
+	return nil
+}
+
+func (f *Fake) SyncUsers(ctx context.Context) error {
+	f.record("SyncUsers")
+	if f.SyncUsersFunc != nil {
+		return f.SyncUsersFunc(ctx)
+	}
+
+	return nil
+}
+
+// Resync succeeds for existing users by default
+func (f *Fake) Resync(ctx context.Context, user, otp1, otp2 string) error {
+	f.record("Resync", user)
+	if f.ResyncFunc != nil {
+		return f.ResyncFunc(ctx, user, otp1, otp2)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.users[user]; !ok {
+		return &ExitError{Command: "-resync", Code: 21}
+	}
+	return nil
+}
+
+func (f *Fake) UnlockUser(ctx context.Context, user string) error {
+	f.record("UnlockUser", user)
+	if f.UnlockUserFunc != nil {
+		return f.UnlockUserFunc(ctx, user)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.users[user]; !ok {
+		return &ExitError{Command: "-unlock", Code: 21}
+	}
+	return nil
+}
+
+// CheckOTP accepts any OTP of existing users by default
+func (f *Fake) CheckOTP(ctx context.Context, user, otp string) error {
+	f.record("CheckOTP", user)
+	if f.CheckOTPFunc != nil {
+		return f.CheckOTPFunc(ctx, user, otp)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.users[user]; !ok {
+		return &ExitError{Command: "check", Code: 21}
+	}
+	return nil
+}
+
+func (f *Fake) Reissue(ctx context.Context, user string, profile *TokenProfile, onStep func(step string)) error {
+	f.record("Reissue", user)
+	if f.ReissueFunc != nil {
+		return f.ReissueFunc(ctx, user, profile, onStep)
+	}
+
+	if onStep != nil {
+		onStep(StepDeleting)
+		onStep(StepCreating)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.users[user] = fakeTokenURL(user)
+	if profile != nil {
+		f.users[user] = fakeProfileTokenURL(user, *profile)
+	}
+	return nil
+}
+
+// Capabilities are all supported by default
+func (f *Fake) Capabilities(ctx context.Context) (Capabilities, error) {
+	f.record("Capabilities")
+	if f.CapabilitiesFunc != nil {
+		return f.CapabilitiesFunc(ctx)
+	}
+
+	return Capabilities{Version: "fake", LDAPUsersSync: true, FastCreateNoPin: true, Create: true}, nil
+}
+
+func (f *Fake) Check(ctx context.Context) error {
+	f.record("Check")
+	if f.CheckFunc != nil {
+		return f.CheckFunc(ctx)
+	}
+
+	return nil
+}
+
+// otpauth URL with random secret, same format as MultiOTP's
+func fakeTokenURL(user string) string {
+	return "otpauth://totp/multiOTP:" + url.PathEscape(user) + "?secret=" + fakeSecret() + "&digits=6&period=30"
+}
+
+// otpauth URL by profile with random secret
+func fakeProfileTokenURL(user string, profile TokenProfile) string {
+	typ := strings.ToLower(profile.Type)
+	params := "&algorithm=" + strings.ToUpper(profile.Algorithm) + "&digits=" + strconv.Itoa(profile.Digits)
+	if typ == TokenTOTP {
+		params += "&period=" + strconv.Itoa(profile.Period)
+	} else {
+		params += "&counter=0"
+	}
+
+	return "otpauth://" + typ + "/multiOTP:" + url.PathEscape(user) + "?secret=" + fakeSecret() + params
+}
+
+// random base32 secret(no padding)
+func fakeSecret() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)
+}
@@ -0,0 +1,222 @@
+package multiotp
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// HTTPConfig of MultiOTP agent connection
+type HTTPConfig struct {
+	// agent base URL, ex. "https://multiotp.example.com:8443"
+	URL string
+	// agent bearer token
+	Token string
+	// CA cert(PEM) to verify agent's TLS cert, empty - system CAs
+	CAFile string
+}
+
+// HTTPClient is Client calling MultiOTP agent on remote host(see agent.go),
+// so the portal may run on separate host(ex. DMZ)
+type HTTPClient struct {
+	baseURL string
+	token   string
+	http    *http.Client
+	opts    Options
+	logger  *slog.Logger
+}
+
+var _ Client = (*HTTPClient)(nil)
+
+// NewHTTPClient returns Client for MultiOTP agent.
+// Reissue strategy is resolved by agent's MultiOTP capabilities,
+// error means the strategy isn't supported or CA file is invalid.
+func NewHTTPClient(ctx context.Context, cfg HTTPConfig, opts Options) (*HTTPClient, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if len(cfg.CAFile) != 0 {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read MultiOTP agent CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certs found in MultiOTP agent CA file %s", cfg.CAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	c := &HTTPClient{
+		baseURL: strings.TrimSuffix(cfg.URL, "/"),
+		token:   cfg.Token,
+		http:    &http.Client{Transport: transport},
+		opts:    opts,
+		logger:  opts.Logger,
+	}
+	if c.logger == nil {
+		c.logger = slog.New(slog.DiscardHandler)
+	}
+
+	strategy, err := resolveStrategy(ctx, c, opts, c.logger)
+	if err != nil {
+		return nil, err
+	}
+	c.opts.Strategy = strategy
+
+	return c, nil
+}
+
+// Call agent with timeout, send in(may be nil) as JSON body,
+// decode JSON response into out(may be nil).
+// Agent errors with MultiOTP exit code are returned as *ExitError.
+func (c *HTTPClient) do(ctx context.Context, timeout time.Duration, method, path string, in any, out any) error {
+	operations.Add(1)
+	defer operations.Done()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	start := time.Now()
+	resp, err := c.http.Do(req)
+	if err != nil {
+		c.logger.Warn("MultiOTP agent request failed", "method", method, "path", path, "duration", time.Since(start), slog.Any("error", err))
+		return fmt.Errorf("multiotp agent %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	c.logger.Debug("MultiOTP agent request finished", "method", method, "path", path, "status", resp.StatusCode, "duration", time.Since(start))
+
+	if resp.StatusCode >= 300 {
+		var agentErr agentError
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		if json.Unmarshal(body, &agentErr) != nil {
+			agentErr.Error = RedactOutput(body)
+		}
+		if agentErr.ExitCode != 0 {
+			return &ExitError{Command: agentErr.Command, Code: agentErr.ExitCode}
+		}
+		return fmt.Errorf("multiotp agent %s %s: %s: %s", method, path, resp.Status, agentErr.Error)
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("multiotp agent %s %s: bad response: %w", method, path, err)
+	}
+
+	return nil
+}
+
+// agent path for user
+func userPath(user string, suffix string) string {
+	return "/v1/users/" + url.PathEscape(user) + suffix
+}
+
+// GetTokenURL returns user's totpURL
+func (c *HTTPClient) GetTokenURL(ctx context.Context, user string) (string, error) {
+	var resp agentTokenURL
+	if err := c.do(ctx, c.opts.Timeout, http.MethodGet, userPath(user, "/token-url"), nil, &resp); err != nil {
+		return "", err
+	}
+
+	if !strings.HasPrefix(resp.TokenURL, "otpauth://") {
+		return "", fmt.Errorf("multiotp agent token URL doesn't match '^otpauth://', got:\n\t\t%s", RedactOutput([]byte(resp.TokenURL)))
+	}
+	return resp.TokenURL, nil
+}
+
+// UserInfo returns user's attributes
+func (c *HTTPClient) UserInfo(ctx context.Context, user string) (UserInfo, error) {
+	info := make(UserInfo)
+	if err := c.do(ctx, c.opts.Timeout, http.MethodGet, userPath(user, ""), nil, &info); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// DeleteUser deletes user
+func (c *HTTPClient) DeleteUser(ctx context.Context, user string) error {
+	return c.do(ctx, c.opts.Timeout, http.MethodDelete, userPath(user, ""), nil, nil)
+}
+
+// CreateUser creates user with new random TOTP token and no PIN
+func (c *HTTPClient) CreateUser(ctx context.Context, user string) error {
+	return c.do(ctx, c.opts.Timeout, http.MethodPost, userPath(user, ""), nil, nil)
+}
+
+// CreateUserProfile creates user with new random token by profile
+func (c *HTTPClient) CreateUserProfile(ctx context.Context, user string, profile TokenProfile) error {
+	return c.do(ctx, c.opts.Timeout, http.MethodPost, userPath(user, ""), profile, nil)
+}
+
+// SetUserAttributes sets user's attributes
+func (c *HTTPClient) SetUserAttributes(ctx context.Context, user string, attrs UserInfo) error {
+	return c.do(ctx, c.opts.Timeout, http.MethodPatch, userPath(user, ""), attrs, nil)
+}
+
+// Resync resynchronizes user's token with two consecutive OTPs
+func (c *HTTPClient) Resync(ctx context.Context, user, otp1, otp2 string) error {
+	return c.do(ctx, c.opts.Timeout, http.MethodPost, userPath(user, "/resync"), agentResync{OTP1: otp1, OTP2: otp2}, nil)
+}
+
+// UnlockUser unlocks user's token
+func (c *HTTPClient) UnlockUser(ctx context.Context, user string) error {
+	return c.do(ctx, c.opts.Timeout, http.MethodPost, userPath(user, "/unlock"), nil, nil)
+}
+
+// CheckOTP checks user's OTP
+func (c *HTTPClient) CheckOTP(ctx context.Context, user, otp string) error {
+	return c.do(ctx, c.opts.Timeout, http.MethodPost, userPath(user, "/check"), agentCheckOTP{OTP: otp}, nil)
+}
+
+// SyncUsers runs LDAP users sync on agent(agent coalesces concurrent syncs)
+func (c *HTTPClient) SyncUsers(ctx context.Context) error {
+	return c.do(ctx, c.opts.SyncTimeout, http.MethodPost, "/v1/sync", nil, nil)
+}
+
+// Reissue user's token with client's strategy, by profile if it isn't nil.
+// onStep(may be nil) is called before every step(StepDeleting, then StepSyncing or StepCreating).
+func (c *HTTPClient) Reissue(ctx context.Context, user string, profile *TokenProfile, onStep func(step string)) error {
+	return reissue(ctx, c, c.opts.Strategy, user, profile, onStep)
+}
+
+// Capabilities of agent's MultiOTP
+func (c *HTTPClient) Capabilities(ctx context.Context) (Capabilities, error) {
+	var caps Capabilities
+	err := c.do(ctx, c.opts.Timeout, http.MethodGet, "/v1/capabilities", nil, &caps)
+	return caps, err
+}
+
+// Check agent is reachable and its MultiOTP is usable
+func (c *HTTPClient) Check(ctx context.Context) error {
+	return c.do(ctx, c.opts.Timeout, http.MethodGet, "/v1/check", nil, nil)
+}
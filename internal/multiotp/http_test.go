@@ -0,0 +1,304 @@
+package multiotp
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAgentToken = "agent-test-token"
+
+// TLS agent server on fake with its cert written to CA file
+func newTestAgent(t *testing.T, fake *Fake) (*httptest.Server, string) {
+	t.Helper()
+
+	ts := httptest.NewUnstartedServer(NewAgentHandler(fake, testAgentToken, slog.New(slog.DiscardHandler)))
+	// TLS handshake errors are expected in tests
+	ts.Config.ErrorLog = log.New(io.Discard, "", 0)
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+
+	return ts, writeCAFile(t, ts)
+}
+
+// write test server's cert to CA file
+func writeCAFile(t *testing.T, ts *httptest.Server) string {
+	t.Helper()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return caFile
+}
+
+func newTestHTTPClient(t *testing.T, cfg HTTPConfig) *HTTPClient {
+	t.Helper()
+
+	c, err := NewHTTPClient(context.Background(), cfg, Options{
+		Strategy:    StrategyAuto,
+		Timeout:     5 * time.Second,
+		SyncTimeout: 5 * time.Second,
+		Logger:      slog.New(slog.DiscardHandler),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestHTTPClientFlow(t *testing.T) {
+	fake := NewFake("alice")
+	ts, caFile := newTestAgent(t, fake)
+	c := newTestHTTPClient(t, HTTPConfig{URL: ts.URL + "/", Token: testAgentToken, CAFile: caFile})
+	ctx := context.Background()
+	if err := c.SetUserAttributes(ctx, "alice", UserInfo{"email": "alice@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// strategy is resolved by agent's capabilities
+	if c.opts.Strategy != StrategyRecreate {
+		t.Errorf("auto strategy resolved to %q, want %q", c.opts.Strategy, StrategyRecreate)
+	}
+
+	tokenURL, err := c.GetTokenURL(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Reissue(ctx, "alice", &testProfile, nil); err != nil {
+		t.Fatal(err)
+	}
+	newURL, err := c.GetTokenURL(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newURL == tokenURL {
+		t.Error("reissue must generate new secret")
+	}
+
+	for _, err := range []error{
+		c.CheckOTP(ctx, "alice", "123456"),
+		c.Resync(ctx, "alice", "111111", "222222"),
+		c.UnlockUser(ctx, "alice"),
+		c.SyncUsers(ctx),
+		c.Check(ctx),
+	} {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	want := []string{
+		"Capabilities", "SetUserAttributes alice",
+		"GetTokenURL alice", "UserInfo alice", "DeleteUser alice", "CreateUserProfile alice compliance",
+		"SetUserAttributes alice", "GetTokenURL alice",
+		"CheckOTP alice", "Resync alice", "UnlockUser alice", "SyncUsers", "Check",
+	}
+	if calls := fake.Calls(); !slices.Equal(calls, want) {
+		t.Errorf("agent calls %q, want %q", calls, want)
+	}
+}
+
+// requests without valid token aren't passed to MultiOTP
+func TestHTTPClientToken(t *testing.T) {
+	fake := NewFake("alice")
+	ts, caFile := newTestAgent(t, fake)
+	c := newTestHTTPClient(t, HTTPConfig{URL: ts.URL, Token: "wrong", CAFile: caFile})
+
+	_, err := c.GetTokenURL(context.Background(), "alice")
+	if err == nil || !strings.Contains(err.Error(), "401 Unauthorized") {
+		t.Errorf("wrong token: got %v, want 401 Unauthorized", err)
+	}
+
+	rs, err := ts.Client().Get(ts.URL + "/v1/check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.Body.Close()
+	if rs.StatusCode != http.StatusUnauthorized {
+		t.Errorf("no token: got %d, want %d", rs.StatusCode, http.StatusUnauthorized)
+	}
+
+	if calls := fake.Calls(); len(calls) != 0 {
+		t.Errorf("unauthorized requests reached MultiOTP: %q", calls)
+	}
+}
+
+func TestHTTPClientTLS(t *testing.T) {
+	ts, caFile := newTestAgent(t, NewFake())
+
+	// agent's self-signed cert isn't trusted by system CAs
+	c := newTestHTTPClient(t, HTTPConfig{URL: ts.URL, Token: testAgentToken})
+	err := c.Check(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Errorf("without CA file: got %v, want certificate error", err)
+	}
+
+	c = newTestHTTPClient(t, HTTPConfig{URL: ts.URL, Token: testAgentToken, CAFile: caFile})
+	if err := c.Check(context.Background()); err != nil {
+		t.Errorf("with CA file: %v", err)
+	}
+
+	badCA := filepath.Join(t.TempDir(), "bad.pem")
+	if err := os.WriteFile(badCA, []byte("not a cert"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, caFile := range []string{badCA, filepath.Join(t.TempDir(), "missing.pem")} {
+		_, err := NewHTTPClient(context.Background(), HTTPConfig{URL: ts.URL, Token: testAgentToken, CAFile: caFile}, Options{Strategy: StrategyAuto})
+		if err == nil {
+			t.Errorf("CA file %s must fail", caFile)
+		}
+	}
+}
+
+// agent down at startup doesn't stop the portal with default strategy
+func TestHTTPClientAgentDown(t *testing.T) {
+	ts, caFile := newTestAgent(t, NewFake())
+	ts.Close()
+	cfg := HTTPConfig{URL: ts.URL, Token: testAgentToken, CAFile: caFile}
+
+	for _, strategy := range []string{StrategyFullSync, StrategyAuto} {
+		c, err := NewHTTPClient(context.Background(), cfg, Options{Strategy: strategy, Timeout: time.Second, Logger: slog.New(slog.DiscardHandler)})
+		if err != nil {
+			t.Errorf("%s: %v", strategy, err)
+			continue
+		}
+		if c.opts.Strategy != StrategyFullSync {
+			t.Errorf("%s resolved to %q, want %q", strategy, c.opts.Strategy, StrategyFullSync)
+		}
+		if err := c.Check(context.Background()); err == nil {
+			t.Errorf("%s: check of down agent must fail", strategy)
+		}
+	}
+
+	_, err := NewHTTPClient(context.Background(), cfg, Options{Strategy: StrategyRecreate, Timeout: time.Second})
+	if err == nil {
+		t.Error("recreate: undetected capabilities must fail")
+	}
+}
+
+// MultiOTP exit codes pass through agent, its status is mapped by error
+func TestHTTPClientErrors(t *testing.T) {
+	fake := NewFake("alice")
+	fake.CheckOTPFunc = func(ctx context.Context, user, otp string) error {
+		return &ExitError{Command: "check", Code: 24}
+	}
+	fake.ResyncFunc = func(ctx context.Context, user, otp1, otp2 string) error {
+		return &ExitError{Command: "-resync", Code: 27}
+	}
+	fake.SyncUsersFunc = func(ctx context.Context) error {
+		return context.DeadlineExceeded
+	}
+	fake.CheckFunc = func(ctx context.Context) error {
+		return errors.New("multiotp -version: exec format error")
+	}
+	ts, caFile := newTestAgent(t, fake)
+	c := newTestHTTPClient(t, HTTPConfig{URL: ts.URL, Token: testAgentToken, CAFile: caFile})
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		err      error
+		wantErr  error // typed error, nil - message only
+		wantCode int   // MultiOTP exit code
+		wantMsg  string
+	}{
+		{"user not found", c.DeleteUser(ctx, "bob"), ErrUserNotFound, 21, ""},
+		{"token locked", c.CheckOTP(ctx, "alice", "123456"), ErrTokenLocked, 24, ""},
+		{"resync failed", c.Resync(ctx, "alice", "111111", "111111"), ErrResyncFailed, 27, ""},
+		{"timeout", c.SyncUsers(ctx), nil, 0, "504 Gateway Timeout"},
+		{"other error", c.Check(ctx), nil, 0, "500 Internal Server Error: multiotp -version: exec format error"},
+	}
+
+	for _, tt := range tests {
+		if tt.err == nil {
+			t.Errorf("%s: no error", tt.name)
+			continue
+		}
+
+		var exitErr *ExitError
+		if tt.wantErr != nil && (!errors.Is(tt.err, tt.wantErr) || !errors.As(tt.err, &exitErr) || exitErr.Code != tt.wantCode) {
+			t.Errorf("%s: got %v, want exit code %d(%v)", tt.name, tt.err, tt.wantCode, tt.wantErr)
+		}
+		if !strings.Contains(tt.err.Error(), tt.wantMsg) {
+			t.Errorf("%s: got %v, want %q", tt.name, tt.err, tt.wantMsg)
+		}
+	}
+
+	// agent statuses by MultiOTP errors
+	statuses := map[string]int{
+		"/v1/users/bob/token-url": http.StatusNotFound,
+		"/v1/check":               http.StatusInternalServerError,
+	}
+	for path, want := range statuses {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testAgentToken)
+		rs, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs.Body.Close()
+		if rs.StatusCode != want {
+			t.Errorf("%s: got %d, want %d", path, rs.StatusCode, want)
+		}
+	}
+}
+
+// responses of stub server that isn't agent(ex. proxy in front of it)
+func TestHTTPClientStubResponses(t *testing.T) {
+	var status int
+	var body string
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testAgentToken {
+			t.Errorf("%s: no agent token", r.URL.Path)
+		}
+		if r.URL.Path == "/v1/capabilities" {
+			w.Write([]byte(`{"LDAPUsersSync": true}`))
+			return
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(ts.Close)
+
+	c := newTestHTTPClient(t, HTTPConfig{URL: ts.URL, Token: testAgentToken, CAFile: writeCAFile(t, ts)})
+	if c.opts.Strategy != StrategyFullSync {
+		t.Errorf("auto strategy resolved to %q, want %q", c.opts.Strategy, StrategyFullSync)
+	}
+
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantMsg string
+	}{
+		{"proxy error", http.StatusBadGateway, "<html>Bad Gateway</html>", "502 Bad Gateway: <html>Bad Gateway</html>"},
+		{"not otpauth URL", http.StatusOK, `{"tokenURL": "https://example.com"}`, "doesn't match '^otpauth://'"},
+		{"bad JSON", http.StatusOK, "<html>login</html>", "bad response"},
+	}
+
+	for _, tt := range tests {
+		status, body = tt.status, tt.body
+		_, err := c.GetTokenURL(context.Background(), "alice")
+		if err == nil || !strings.Contains(err.Error(), tt.wantMsg) {
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.wantMsg)
+		}
+	}
+}
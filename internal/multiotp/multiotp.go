@@ -1,9 +1,12 @@
 package multiotp
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"os/exec"
-	"regexp"
+	"log/slog"
+	"sync"
+	"time"
 )
 
 /*
@@ -11,97 +14,206 @@ multiotp -qrcode user png_file_name.png
 multiotp -update-pin user pin
 multiotp -remove-token user
 
-# reissue token
+# reissue token(full-sync strategy)
 1) multiotp -delete user
 2) multiotp -ldap-users-sync
 
+# reissue token(recreate strategy)
+1) multiotp -user-info user(attributes to restore)
+2) multiotp -delete user
+3) multiotp -fastcreatenopin user
+4) multiotp -set user description=... email=... sms=... group=...
+
+# reissue token by profile(any strategy)
+1) multiotp -user-info user(attributes to restore)
+2) multiotp -delete user
+3) multiotp -create -no-prefix-pin user TOTP|HOTP hex_seed pin digits period|counter
+4) multiotp -set user token_algo_suffix=SHA256|SHA512(non-SHA1 only)
+5) multiotp -set user description=... email=... sms=... group=...
+
+# there is no token-only regeneration: '-remove-token user' leaves user without token,
+# '-fastcreatenopin' & '-create' refuse existing user(22), '-ldap-users-sync' has no user filter
+
+# resync token with two consecutive OTPs(14 - ok, 27 - failed)
+multiotp -resync user otp1 otp2
+
+# unlock user locked after too many failed OTPs
+multiotp -unlock user
+
+# check user's OTP(0 - ok)
+multiotp user otp
+
 # get totpURL
 multiotp -urllink user
 # otpauth://totp/multiOTP:<NAME>%20<SURNANME>?secret=<BASE32 SEED>&digits=6&period=30
 */
 
-// Get MultiOTP user's totpURL
-func GetMultiOTPTokenURL(user string, multiOTPBinPath string) ([]byte, error) {
-	// define command to get TOTP URL for user
-	cmd := exec.Command(multiOTPBinPath, "-urllink", user)
-	// due to multiotp console tools throw Exit codes every time
-	// need to check err.ExitCode, because err will be always
-	// exit status 17: is success for '-urllink' cmd
-	out, err := cmd.Output()
-	if err, ok := err.(*exec.ExitError); ok {
-		// 17 INFO: UrlLink successfully created
-		// 21 ERROR: User doesn't exist
-		switch {
-		case err.ExitCode() == 21:
-			return nil, fmt.Errorf("%s doesn't exist", user)
-		case err.ExitCode() != 17:
-			return nil, err
-		}
-	}
+// In-flight MultiOTP operations(single commands and whole reissues),
+// to let the program wait for them on shutdown
+var operations sync.WaitGroup
 
-	// check output is what expected
-	pattern := regexp.MustCompile(`^otpauth:`)
-	if !pattern.Match(out) {
-		return nil, fmt.Errorf("mutliotp command doesn't match '^otpauth://', output:\n\t\t%s", out)
+// WaitOperations waits for in-flight MultiOTP operations to finish or ctx to be done
+func WaitOperations(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		operations.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	return out, nil
 }
 
-// Delete MultiOTP User
-// If user doesn't exist - returns noting(not error)
-func delMultiOTPUser(multiOTPBinPath string, user string) error {
-	// define command to delete user
-	cmd := exec.Command(multiOTPBinPath, "-delete", user)
-	// due to multiotp console tools throw Exit codes every time
-	// need to check err.ExitCode, because err will be always
-	// 12 INFO: User successfully deleted: is success for '-delete user' cmd
-	// OR
-	// 19 INFO: Requested operation successfully done: is success for '-delete user' cmd
-	// 21 ERROR: User doesn't exist: not error
-	_, err := cmd.Output()
-	if err, ok := err.(*exec.ExitError); ok {
-		switch {
-		case err.ExitCode() == 21:
-			return nil
-		case err.ExitCode() != 12 && err.ExitCode() != 19:
-			return err
-		}
-	}
+// Client is MultiOTP operations used by the portal.
+// CLI runs local MultiOTP binary, HTTPClient calls MultiOTP agent on remote host,
+// Fake is in-memory implementation for tests.
+// ctx is request's or job's context: operation is cancelled with it.
+type Client interface {
+	// GetTokenURL returns user's otpauth:// URL
+	GetTokenURL(ctx context.Context, user string) (string, error)
+	// UserInfo returns user's attributes
+	UserInfo(ctx context.Context, user string) (UserInfo, error)
+	// DeleteUser deletes user(ErrUserNotFound if it doesn't exist)
+	DeleteUser(ctx context.Context, user string) error
+	// CreateUser creates user with new random TOTP token and no PIN
+	CreateUser(ctx context.Context, user string) error
+	// CreateUserProfile creates user with new random token by profile
+	CreateUserProfile(ctx context.Context, user string, profile TokenProfile) error
+	// SetUserAttributes sets user's attributes(RestoredAttributes keys only)
+	SetUserAttributes(ctx context.Context, user string, attrs UserInfo) error
+	// SyncUsers syncs MultiOTP users with LDAP
+	SyncUsers(ctx context.Context) error
+	// Resync resynchronizes user's token(clock drift) with two consecutive OTPs,
+	// ErrResyncFailed if they don't match
+	Resync(ctx context.Context, user, otp1, otp2 string) error
+	// UnlockUser unlocks user's token locked after too many failed OTPs
+	UnlockUser(ctx context.Context, user string) error
+	// CheckOTP checks user's OTP(ErrAuthFailed, ErrTokenLocked, etc. if it's rejected).
+	// Failed checks count towards MultiOTP's token lock, as any other login.
+	CheckOTP(ctx context.Context, user, otp string) error
+	// Reissue regenerates user's token, by profile if it isn't nil,
+	// onStep(may be nil) is called before every step(Step* consts)
+	Reissue(ctx context.Context, user string, profile *TokenProfile, onStep func(step string)) error
+	// Capabilities returns what installed MultiOTP supports
+	Capabilities(ctx context.Context) (Capabilities, error)
+	// Check checks MultiOTP is usable
+	Check(ctx context.Context) error
+}
 
-	return nil
+// MultiOTP backends
+const (
+	// local MultiOTP binary(CLI)
+	BackendCLI = "cli"
+	// MultiOTP agent on remote host(HTTPClient)
+	BackendHTTP = "http"
+)
+
+// Backends is list of supported MultiOTP backends
+var Backends = []string{BackendCLI, BackendHTTP}
+
+// UserInfo is user's attributes by '-user-info user', ex. "description"
+type UserInfo map[string]string
+
+// Options of CLI & HTTPClient
+type Options struct {
+	// reissue strategy(Strategy* consts), 'auto' is resolved by constructor
+	Strategy string
+	// max duration of single-user commands(-urllink, -delete, etc.)
+	Timeout time.Duration
+	// max duration of '-ldap-users-sync'
+	SyncTimeout time.Duration
+	// token profiles are configured: MultiOTP must support '-create'
+	Profiles bool
+	// PHP interpreter to run multiotp.php(CLI only, used if binary path is *.php)
+	PHPPath string
+	// commands' output & strategy choice are logged here, nil - discarded
+	Logger *slog.Logger
 }
 
-// Resync MultiOTP Users
-func resyncMultiOTPUsers(multiOTPBinPath string) error {
-	// define command to delete user
-	cmd := exec.Command(multiOTPBinPath, "-ldap-users-sync")
-	// due to multiotp console tools throw Exit codes every time
-	// need to check err.ExitCode, because err will be always
-	// 19 INFO: Requested operation successfully done: is success for '-delete user' cmd
-	_, err := cmd.Output()
-
-	if err, ok := err.(*exec.ExitError); ok {
-		if err.ExitCode() != 19 {
-			return err
+// Reissue steps, passed to Reissue's onStep
+const (
+	StepDeleting = "deleting"
+	StepSyncing  = "syncing"
+	StepCreating = "creating"
+)
+
+// Reissue user's token with client's operations and resolved strategy,
+// with profile(may be nil) user is always recreated: sync can't set token params.
+// Recreated user gets its attributes back(see RestoredAttributes),
+// LDAP sync flag comes back with the next full sync.
+// Reissue is tracked as one operation: user must not be left deleted
+// without resync on shutdown.
+func reissue(ctx context.Context, c Client, strategy string, user string, profile *TokenProfile, onStep func(step string)) error {
+	operations.Add(1)
+	defer operations.Done()
+
+	if onStep == nil {
+		onStep = func(string) {}
+	}
+
+	// remember attributes of user to be recreated,
+	// not existing user has nothing to restore
+	recreate := profile != nil || strategy == StrategyRecreate
+	var attrs UserInfo
+	if recreate {
+		info, err := c.UserInfo(ctx, user)
+		if err != nil && !errors.Is(err, ErrUserNotFound) {
+			return fmt.Errorf("reissue qr: failed to get user info:\n\t%w", err)
 		}
+		attrs = info.restored()
 	}
 
-	return nil
-}
+	// first del user from MultiOTP db,
+	// not existing user is ok: it will be created
+	onStep(StepDeleting)
+	err := c.DeleteUser(ctx, user)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		return fmt.Errorf("reissue qr: failed to del user:\n\t%w", err)
+	}
 
-// Reissue MultiOTP QR
-func ReissueMultiOTPQR(multiOTPBinPath string, user string) error {
-	// first del user from MultiOTP db
-	err := delMultiOTPUser(multiOTPBinPath, user)
-	if err != nil {
-		return fmt.Errorf("reissue qr: failed to del user:\n\t%v", err)
+	switch {
+	case profile != nil:
+		// create only this user with new token by profile
+		onStep(StepCreating)
+		err = c.CreateUserProfile(ctx, user, *profile)
+		if err != nil {
+			// user is already deleted: bring it back by sync(with default token),
+			// user mustn't be left without token
+			onStep(StepSyncing)
+			errSync := c.SyncUsers(ctx)
+			if errSync != nil {
+				return fmt.Errorf("reissue qr: failed to create user by profile %q:\n\t%w\n\tfailed to resync users:\n\t%w", profile.Name, err, errSync)
+			}
+			return fmt.Errorf("reissue qr: failed to create user by profile %q(user is restored by sync):\n\t%w", profile.Name, err)
+		}
+	case strategy == StrategyRecreate:
+		// create only this user with new token
+		onStep(StepCreating)
+		err = c.CreateUser(ctx, user)
+		if err != nil {
+			return fmt.Errorf("reissue qr: failed to create user:\n\t%w", err)
+		}
+	default:
+		// second resync MultiOTP db to get same user back with new QR generated
+		// may take some time to resync(depend of users number),
+		// concurrent reissues share one sync
+		onStep(StepSyncing)
+		err = c.SyncUsers(ctx)
+		if err != nil {
+			return fmt.Errorf("reissue qr: failed to resync users:\n\t%w", err)
+		}
 	}
 
-	// second resync MultiOTP db to get same user back with new QR generated
-	// may take some time to resync(depend of users number)
-	err = resyncMultiOTPUsers(multiOTPBinPath)
-	if err != nil {
-		return fmt.Errorf("reissue qr: failed to resync users:\n\t%v", err)
+	// user has new token: restore its attributes
+	if recreate && len(attrs) != 0 {
+		err = c.SetUserAttributes(ctx, user, attrs)
+		if err != nil {
+			return fmt.Errorf("reissue qr: token is reissued, but failed to restore user's attributes:\n\t%w", err)
+		}
 	}
 
 	return nil
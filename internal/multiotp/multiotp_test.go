@@ -0,0 +1,162 @@
+package multiotp
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"slices"
+	"testing"
+)
+
+var testProfile = TokenProfile{Name: "compliance", Type: TokenTOTP, Algorithm: "SHA256", Digits: 8, Period: 60}
+
+func TestReissueProfile(t *testing.T) {
+	fake := NewFake("alice")
+
+	err := reissue(context.Background(), fake, StrategyRecreate, "alice", &testProfile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"UserInfo alice", "DeleteUser alice", "CreateUserProfile alice compliance"}
+	if calls := fake.Calls(); !slices.Equal(calls, want) {
+		t.Errorf("calls %q, want %q", calls, want)
+	}
+}
+
+// user deleted before failed '-create' is restored by sync
+func TestReissueProfileCreateFailed(t *testing.T) {
+	fake := NewFake("alice")
+	fake.CreateUserProfileFunc = func(ctx context.Context, user string, profile TokenProfile) error {
+		return &ExitError{Command: "-create", Code: 99}
+	}
+
+	var steps []string
+	err := reissue(context.Background(), fake, StrategyRecreate, "alice", &testProfile, func(step string) {
+		steps = append(steps, step)
+	})
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.Command != "-create" {
+		t.Fatalf("got %v, want -create error", err)
+	}
+
+	want := []string{"UserInfo alice", "DeleteUser alice", "CreateUserProfile alice compliance", "SyncUsers"}
+	if calls := fake.Calls(); !slices.Equal(calls, want) {
+		t.Errorf("calls %q, want %q", calls, want)
+	}
+	if want := []string{StepDeleting, StepCreating, StepSyncing}; !slices.Equal(steps, want) {
+		t.Errorf("steps %q, want %q", steps, want)
+	}
+}
+
+// recreated user gets its attributes back, other info isn't set
+func TestReissueRestoresAttributes(t *testing.T) {
+	attrs := UserInfo{"description": "Alice Smith", "email": "alice@example.com", "group": "staff"}
+
+	for _, profile := range []*TokenProfile{nil, &testProfile} {
+		fake := NewFake("alice")
+		if err := fake.SetUserAttributes(context.Background(), "alice", attrs); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := reissue(context.Background(), fake, StrategyRecreate, "alice", profile, nil); err != nil {
+			t.Fatal(err)
+		}
+		info, err := fake.UserInfo(context.Background(), "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.restored(); !maps.Equal(got, attrs) {
+			t.Errorf("profile %v: attributes %v, want %v", profile, got, attrs)
+		}
+	}
+
+	// user without attributes(or not existing one) has nothing to restore
+	fake := NewFake()
+	if err := reissue(context.Background(), fake, StrategyRecreate, "bob", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"UserInfo bob", "DeleteUser bob", "CreateUser bob"}
+	if calls := fake.Calls(); !slices.Equal(calls, want) {
+		t.Errorf("calls %q, want %q", calls, want)
+	}
+}
+
+func TestUserInfoSetArgs(t *testing.T) {
+	args, err := UserInfo{"group": "staff", "description": "Alice Smith"}.setArgs("alice")
+	if want := []string{"-set", "alice", "description=Alice Smith", "group=staff"}; err != nil || !slices.Equal(args, want) {
+		t.Errorf("got %q, %v; want %q", args, err, want)
+	}
+
+	// PIN & other options mustn't be set as attributes
+	if _, err := (UserInfo{"pin": "0000"}).setArgs("alice"); err == nil {
+		t.Error("pin attribute must fail")
+	}
+}
+
+func TestResolveStrategyProfiles(t *testing.T) {
+	fake := NewFake()
+	fake.CapabilitiesFunc = func(ctx context.Context) (Capabilities, error) {
+		return Capabilities{Version: "5.0", LDAPUsersSync: true, FastCreateNoPin: true}, nil
+	}
+
+	_, err := resolveStrategy(context.Background(), fake, Options{Strategy: StrategyAuto, Profiles: true}, slog.New(slog.DiscardHandler))
+	if err == nil {
+		t.Error("token profiles without '-create' must fail")
+	}
+}
+
+func TestResolveStrategy(t *testing.T) {
+	all := Capabilities{LDAPUsersSync: true, FastCreateNoPin: true}
+	syncOnly := Capabilities{LDAPUsersSync: true}
+	createOnly := Capabilities{FastCreateNoPin: true}
+
+	tests := []struct {
+		strategy string
+		caps     Capabilities
+		want     string // empty - error
+	}{
+		{StrategyAuto, all, StrategyRecreate},
+		{StrategyAuto, syncOnly, StrategyFullSync},
+		{StrategyAuto, Capabilities{}, ""},
+		{StrategyRecreate, createOnly, StrategyRecreate},
+		{StrategyRecreate, syncOnly, ""},
+		{StrategyFullSync, syncOnly, StrategyFullSync},
+		{StrategyFullSync, createOnly, ""},
+		{"filter-sync", all, ""},
+	}
+
+	for _, tt := range tests {
+		got, err := ResolveStrategy(tt.strategy, tt.caps)
+		if got != tt.want || (err == nil) != (len(tt.want) != 0) {
+			t.Errorf("ResolveStrategy(%q, %+v) = %q, %v; want %q", tt.strategy, tt.caps, got, err, tt.want)
+		}
+	}
+}
+
+// 'auto' & 'full-sync'(default) fall back to full sync if detection fails,
+// only 'recreate' fails
+func TestResolveStrategyDetectionFailed(t *testing.T) {
+	fake := NewFake()
+	fake.CapabilitiesFunc = func(ctx context.Context) (Capabilities, error) {
+		return Capabilities{}, context.DeadlineExceeded
+	}
+	logger := slog.New(slog.DiscardHandler)
+
+	tests := []struct {
+		opts Options
+		want string // empty - error
+	}{
+		{Options{Strategy: StrategyFullSync}, StrategyFullSync}, // default config
+		{Options{Strategy: StrategyAuto}, StrategyFullSync},
+		{Options{Strategy: StrategyFullSync, Profiles: true}, StrategyFullSync},
+		{Options{Strategy: StrategyRecreate}, ""},
+	}
+
+	for _, tt := range tests {
+		got, err := resolveStrategy(context.Background(), fake, tt.opts, logger)
+		if got != tt.want || (err == nil) != (len(tt.want) != 0) {
+			t.Errorf("%+v: got %q, %v; want %q", tt.opts, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package multiotp
+
+// Default MultiOTP paths on Linux: multiotp.php of MultiOTP's Linux install,
+// run by PHP interpreter
+const (
+	DefaultBinPath = "/usr/local/bin/multiotp/multiotp.php"
+	DefaultPHPPath = "/usr/bin/php"
+)
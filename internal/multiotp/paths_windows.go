@@ -0,0 +1,9 @@
+//go:build windows
+
+package multiotp
+
+// Default MultiOTP paths on Windows: native multiotp.exe, no PHP needed
+const (
+	DefaultBinPath = "c:/MultiOTP/windows/multiotp.exe"
+	DefaultPHPPath = ""
+)
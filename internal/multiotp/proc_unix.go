@@ -0,0 +1,18 @@
+//go:build !windows
+
+package multiotp
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// Run command in its own process group and kill the whole group on cancel:
+// multiotp wrapper script runs php, which would outlive killed parent
+func killProcessTree(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		// negative pid is process group
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
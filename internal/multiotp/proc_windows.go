@@ -0,0 +1,22 @@
+//go:build windows
+
+package multiotp
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+// Kill command with its child processes on cancel:
+// multiotp.exe runs php.exe, which would outlive killed parent
+func killProcessTree(cmd *exec.Cmd) {
+	cmd.Cancel = func() error {
+		// /T - with child processes, /F - forcefully
+		err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+		if err != nil {
+			// taskkill isn't available or failed: kill at least the parent
+			return cmd.Process.Kill()
+		}
+		return nil
+	}
+}
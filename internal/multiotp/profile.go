@@ -0,0 +1,92 @@
+package multiotp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Token types
+const (
+	TokenTOTP = "totp"
+	TokenHOTP = "hotp"
+)
+
+// Token hash algorithms
+var TokenAlgorithms = []string{"SHA1", "SHA256", "SHA512"}
+
+// TokenProfile is admin-defined token configuration chosen on reissue,
+// ex. 8 digits SHA256 TOTP for compliance zone users
+type TokenProfile struct {
+	Name      string `json:"name" yaml:"name" toml:"name"`
+	Type      string `json:"type" yaml:"type" toml:"type"`                // totp or hotp
+	Algorithm string `json:"algorithm" yaml:"algorithm" toml:"algorithm"` // SHA1, SHA256 or SHA512
+	Digits    int    `json:"digits" yaml:"digits" toml:"digits"`          // 6..8
+	Period    int    `json:"period" yaml:"period" toml:"period"`          // seconds, TOTP only
+}
+
+// Validate checks profile values
+func (p TokenProfile) Validate() error {
+	var errs []error
+	if len(strings.TrimSpace(p.Name)) == 0 {
+		errs = append(errs, errors.New("name is required"))
+	}
+	switch strings.ToLower(p.Type) {
+	case TokenTOTP:
+		if p.Period < 1 {
+			errs = append(errs, fmt.Errorf("period must be positive for totp, got %d", p.Period))
+		}
+	case TokenHOTP:
+	default:
+		errs = append(errs, fmt.Errorf("type must be %q or %q, got %q", TokenTOTP, TokenHOTP, p.Type))
+	}
+	if !slices.Contains(TokenAlgorithms, strings.ToUpper(p.Algorithm)) {
+		errs = append(errs, fmt.Errorf("algorithm must be one of %q, got %q", TokenAlgorithms, p.Algorithm))
+	}
+	if p.Digits < 6 || p.Digits > 8 {
+		errs = append(errs, fmt.Errorf("digits must be 6..8, got %d", p.Digits))
+	}
+
+	return errors.Join(errs...)
+}
+
+// '-create' args for profile: user, type, hex seed, PIN(unused: no prefix PIN),
+// digits, period(TOTP) or start counter(HOTP)
+func (p TokenProfile) createArgs(user string) []string {
+	last := "0"
+	if strings.ToLower(p.Type) == TokenTOTP {
+		last = strconv.Itoa(p.Period)
+	}
+
+	return []string{"-create", "-no-prefix-pin", user, strings.ToUpper(p.Type), newSeed(p.Algorithm), newPIN(), strconv.Itoa(p.Digits), last}
+}
+
+// random hex seed, as long as algorithm's hash(RFC 4226/6238 recommendation)
+func newSeed(algorithm string) string {
+	size := 20
+	switch strings.ToUpper(algorithm) {
+	case "SHA256":
+		size = 32
+	case "SHA512":
+		size = 64
+	}
+
+	b := make([]byte, size)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// random 4 digits PIN, '-create' needs one even without prefix PIN
+func newPIN() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	pin := make([]byte, len(b))
+	for i := range b {
+		pin[i] = '0' + b[i]%10
+	}
+	return string(pin)
+}
@@ -0,0 +1,112 @@
+package multiotp
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Reissue strategies
+const (
+	// '-delete user' + '-ldap-users-sync': works with any MultiOTP,
+	// but syncs the whole directory(slow on big ones)
+	StrategyFullSync = "full-sync"
+	// '-delete user' + '-fastcreatenopin user': regenerates only user's token,
+	// user's attributes are restored by '-set'(see reissue), LDAP sync flag is back with the next full sync
+	StrategyRecreate = "recreate"
+	// the fastest strategy supported by installed MultiOTP
+	StrategyAuto = "auto"
+)
+
+// Strategies is list of supported reissue strategies
+var Strategies = []string{StrategyAuto, StrategyFullSync, StrategyRecreate}
+
+// Capabilities of installed MultiOTP binary
+type Capabilities struct {
+	Version         string `json:"version"`
+	LDAPUsersSync   bool   `json:"ldapUsersSync"`   // -ldap-users-sync(full-sync strategy)
+	FastCreateNoPin bool   `json:"fastCreateNoPin"` // -fastcreatenopin user(recreate strategy)
+	Create          bool   `json:"create"`          // -create user algo seed pin digits period(token profiles)
+}
+
+// multiOTP version in '-help' header, ex. "multiOTP 5.9.7.1"
+var versionPattern = regexp.MustCompile(`(?i)multiOTP\s+(\d+(?:\.\d+)+)`)
+
+// '-create' command(not '-createga', etc.)
+var createPattern = regexp.MustCompile(`-create\s`)
+
+// Look for supported commands and version in 'multiotp -help' output
+func parseCapabilities(help string) Capabilities {
+	caps := Capabilities{
+		LDAPUsersSync:   strings.Contains(help, "-ldap-users-sync"),
+		FastCreateNoPin: strings.Contains(help, "-fastcreatenopin"),
+		Create:          createPattern.MatchString(help),
+	}
+	if m := versionPattern.FindStringSubmatch(help); m != nil {
+		caps.Version = m[1]
+	}
+
+	return caps
+}
+
+// ResolveStrategy returns concrete strategy for configured one:
+// 'auto' is resolved by capabilities, others are checked to be supported
+func ResolveStrategy(strategy string, caps Capabilities) (string, error) {
+	switch strategy {
+	case StrategyAuto:
+		switch {
+		case caps.FastCreateNoPin:
+			return StrategyRecreate, nil
+		case caps.LDAPUsersSync:
+			return StrategyFullSync, nil
+		}
+		return "", fmt.Errorf("reissue strategy %q needs '-fastcreatenopin' or '-ldap-users-sync', none is supported by MultiOTP %s", strategy, caps.Version)
+	case StrategyRecreate:
+		if !caps.FastCreateNoPin {
+			return "", fmt.Errorf("reissue strategy %q needs '-fastcreatenopin', not supported by MultiOTP %s", strategy, caps.Version)
+		}
+		return strategy, nil
+	case StrategyFullSync:
+		if !caps.LDAPUsersSync {
+			return "", fmt.Errorf("reissue strategy %q needs '-ldap-users-sync', not supported by MultiOTP %s", strategy, caps.Version)
+		}
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("unsupported reissue strategy %q", strategy)
+	}
+}
+
+// Resolve configured strategy with client's capabilities and log the choice.
+// If detection fails(ex. 'multiotp -help' timed out or agent is down at startup),
+// 'auto' and 'full-sync' fall back to full sync with warning, only 'recreate' fails:
+// its command can't be checked then.
+// Token profiles need '-create', otherwise reissue by profile would leave user deleted.
+func resolveStrategy(ctx context.Context, c Client, opts Options, logger *slog.Logger) (string, error) {
+	caps, err := c.Capabilities(ctx)
+	if err != nil {
+		switch opts.Strategy {
+		case StrategyAuto, StrategyFullSync:
+			logger.Warn("failed to detect MultiOTP capabilities, falling back to full sync",
+				"reissueStrategy", opts.Strategy, "strategy", StrategyFullSync, slog.Any("error", err))
+			return StrategyFullSync, nil
+		case StrategyRecreate:
+			return "", fmt.Errorf("reissue strategy %q needs '-fastcreatenopin', failed to detect MultiOTP capabilities: %w", opts.Strategy, err)
+		default:
+			return "", fmt.Errorf("unsupported reissue strategy %q", opts.Strategy)
+		}
+	}
+
+	if opts.Profiles && !caps.Create {
+		return "", fmt.Errorf("token profiles need '-create', not supported by MultiOTP %s", caps.Version)
+	}
+
+	resolved, err := ResolveStrategy(opts.Strategy, caps)
+	if err != nil {
+		return "", err
+	}
+
+	logger.Info("reissue strategy chosen", "reissueStrategy", opts.Strategy, "strategy", resolved, "multiOTPVersion", caps.Version)
+	return resolved, nil
+}
@@ -0,0 +1,76 @@
+package multiotp
+
+import (
+	"context"
+	"sync"
+)
+
+// Process-wide '-ldap-users-sync' coordinator.
+// Sync is whole MultiOTP db operation, so only one runs at a time.
+// Requests arriving while sync is running are coalesced into one follow-up sync
+// (sync started earlier may have missed their changes, ex. just deleted user),
+// every waiting caller gets that follow-up sync's result.
+var usersSync = &syncCoordinator{}
+
+// one sync run and its waiters
+type syncRound struct {
+	run  func(ctx context.Context) error
+	done chan struct{}
+	err  error
+	// callers joined follow-up round
+	waiters int
+}
+
+type syncCoordinator struct {
+	mu      sync.Mutex
+	running bool
+	// follow-up round, nil if no requests came during current sync
+	pending *syncRound
+}
+
+// run sync or join follow-up round, wait for the result or ctx to be done.
+// Sync itself isn't cancelled with ctx: other callers may wait for it.
+func (c *syncCoordinator) sync(ctx context.Context, run func(ctx context.Context) error) error {
+	c.mu.Lock()
+
+	var round *syncRound
+	if c.running {
+		// sync is running: join follow-up round
+		if c.pending == nil {
+			c.pending = &syncRound{done: make(chan struct{})}
+		}
+		// the latest run wins(client may be changed by config reload)
+		c.pending.run = run
+		c.pending.waiters++
+		round = c.pending
+	} else {
+		// no sync is running: start one
+		c.running = true
+		round = &syncRound{run: run, done: make(chan struct{})}
+		go c.loop(round)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-round.done:
+		return round.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run rounds one by one until there is no follow-up round
+func (c *syncCoordinator) loop(round *syncRound) {
+	for round != nil {
+		round.err = round.run(context.Background())
+		close(round.done)
+
+		c.mu.Lock()
+		round = c.pending
+		c.pending = nil
+		if round == nil {
+			c.running = false
+		}
+		c.mu.Unlock()
+	}
+}
@@ -0,0 +1,173 @@
+package multiotp
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// sync func blocked until released, counting runs and overlaps
+type testSync struct {
+	runs     atomic.Int32
+	active   atomic.Int32
+	overlaps atomic.Int32
+	started  chan int32      // run number, on run start
+	release  chan struct{}   // one value per run
+	errs     map[int32]error // result by run number
+	ctxErrs  chan error      // run's ctx error after release
+}
+
+func newTestSync(errs map[int32]error) *testSync {
+	return &testSync{
+		started: make(chan int32, 10),
+		release: make(chan struct{}, 10),
+		errs:    errs,
+		ctxErrs: make(chan error, 10),
+	}
+}
+
+func (s *testSync) run(ctx context.Context) error {
+	if s.active.Add(1) > 1 {
+		s.overlaps.Add(1)
+	}
+	defer s.active.Add(-1)
+
+	n := s.runs.Add(1)
+	s.started <- n
+	<-s.release
+	s.ctxErrs <- ctx.Err()
+
+	return s.errs[n]
+}
+
+// wait for run to start
+func (s *testSync) waitStarted(t *testing.T, want int32) {
+	t.Helper()
+
+	select {
+	case n := <-s.started:
+		if n != want {
+			t.Fatalf("run %d started, want %d", n, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("run %d hasn't started", want)
+	}
+}
+
+// wait for n callers to join follow-up round
+func waitWaiters(t *testing.T, c *syncCoordinator, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.mu.Lock()
+		waiters := 0
+		if c.pending != nil {
+			waiters = c.pending.waiters
+		}
+		c.mu.Unlock()
+
+		if waiters == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d callers joined follow-up round, want %d", waiters, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// callers arriving during sync share one follow-up sync and get its result
+func TestSyncCoalesce(t *testing.T) {
+	errFirst := errors.New("first sync failed")
+	errFollowUp := errors.New("follow-up sync failed")
+	s := newTestSync(map[int32]error{1: errFirst, 2: errFollowUp})
+	c := &syncCoordinator{}
+	ctx := context.Background()
+
+	first := make(chan error, 1)
+	go func() { first <- c.sync(ctx, s.run) }()
+	s.waitStarted(t, 1)
+
+	const n = 10
+	var wg sync.WaitGroup
+	late := make(chan error, n)
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			late <- c.sync(ctx, s.run)
+		}()
+	}
+	waitWaiters(t, c, n)
+
+	// first run finishes, follow-up one starts for all late callers
+	s.release <- struct{}{}
+	if err := <-first; !errors.Is(err, errFirst) {
+		t.Errorf("first caller: got %v, want %v", err, errFirst)
+	}
+	s.waitStarted(t, 2)
+	s.release <- struct{}{}
+
+	wg.Wait()
+	close(late)
+	for err := range late {
+		if !errors.Is(err, errFollowUp) {
+			t.Errorf("late caller: got %v, want follow-up's %v", err, errFollowUp)
+		}
+	}
+
+	if runs := s.runs.Load(); runs != 2 {
+		t.Errorf("%d runs, want 2(in-flight + one follow-up)", runs)
+	}
+	if overlaps := s.overlaps.Load(); overlaps != 0 {
+		t.Errorf("%d runs overlapped", overlaps)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.running || c.pending != nil {
+		t.Error("coordinator must be idle after all rounds")
+	}
+}
+
+// cancelled caller returns at once, shared sync isn't cancelled
+func TestSyncCallerCancelled(t *testing.T) {
+	s := newTestSync(nil)
+	c := &syncCoordinator{}
+
+	first := make(chan error, 1)
+	go func() { first <- c.sync(context.Background(), s.run) }()
+	s.waitStarted(t, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan error, 1)
+	go func() { cancelled <- c.sync(ctx, s.run) }()
+	waitWaiters(t, c, 1)
+	cancel()
+
+	select {
+	case err := <-cancelled:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("cancelled caller: got %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancelled caller must return without waiting for sync")
+	}
+
+	// both runs go on with their own(not cancelled) ctx
+	s.release <- struct{}{}
+	if err := <-first; err != nil {
+		t.Errorf("first caller: %v", err)
+	}
+	s.waitStarted(t, 2)
+	s.release <- struct{}{}
+	for range 2 {
+		if err := <-s.ctxErrs; err != nil {
+			t.Errorf("sync run cancelled with caller: %v", err)
+		}
+	}
+}
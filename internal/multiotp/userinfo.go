@@ -0,0 +1,107 @@
+package multiotp
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// time layouts of dates in '-user-info' output
+var userInfoTimeLayouts = []string{
+	"2006-01-02 15:04:05",
+	time.RFC3339,
+	"2006-01-02",
+}
+
+// RestoredAttributes are user's attributes('-user-info' keys, same as '-set' options)
+// restored after user is recreated by reissue
+var RestoredAttributes = []string{"description", "email", "sms", "group"}
+
+// non-empty RestoredAttributes, nil if there are none
+func (i UserInfo) restored() UserInfo {
+	var attrs UserInfo
+	for _, key := range RestoredAttributes {
+		if value, ok := i.lookup(key); ok {
+			if attrs == nil {
+				attrs = make(UserInfo)
+			}
+			attrs[key] = value
+		}
+	}
+	return attrs
+}
+
+// '-set' args for attributes(sorted by key), error on keys
+// other than RestoredAttributes(ex. pin mustn't be set this way)
+func (i UserInfo) setArgs(user string) ([]string, error) {
+	args := []string{"-set", user}
+	for _, key := range slices.Sorted(maps.Keys(i)) {
+		if !slices.Contains(RestoredAttributes, key) {
+			return nil, fmt.Errorf("user attribute %q can't be set, must be one of %q", key, RestoredAttributes)
+		}
+		args = append(args, key+"="+i[key])
+	}
+	return args, nil
+}
+
+// first non-empty value of keys
+func (i UserInfo) lookup(keys ...string) (string, bool) {
+	for _, key := range keys {
+		if value, ok := i[key]; ok && len(value) != 0 {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// bool value of keys("1"/"0", "yes"/"no", "true"/"false"), ok is false if unknown
+func (i UserInfo) flag(keys ...string) (value bool, ok bool) {
+	raw, found := i.lookup(keys...)
+	if !found {
+		return false, false
+	}
+
+	switch strings.ToLower(raw) {
+	case "1", "yes", "true", "on":
+		return true, true
+	case "0", "no", "false", "off":
+		return false, true
+	}
+	return false, false
+}
+
+// Locked returns true if user's token is locked(too many failed attempts),
+// ok is false if user info has no such attribute
+func (i UserInfo) Locked() (locked bool, ok bool) {
+	return i.flag("locked")
+}
+
+// OutOfSync returns true if user's token is delayed/out of sync,
+// ok is false if user info has no such attribute
+func (i UserInfo) OutOfSync() (outOfSync bool, ok bool) {
+	return i.flag("out_of_sync", "delayed")
+}
+
+// Created returns time user's token was created(reissue recreates it),
+// ok is false if user info has no such attribute or it can't be parsed
+func (i UserInfo) Created() (time.Time, bool) {
+	raw, found := i.lookup("created", "creation_date", "create_time")
+	if !found {
+		return time.Time{}, false
+	}
+
+	for _, layout := range userInfoTimeLayouts {
+		if t, err := time.ParseInLocation(layout, raw, time.Local); err == nil {
+			return t, true
+		}
+	}
+	// unix seconds
+	if sec, err := strconv.ParseInt(raw, 10, 64); err == nil && sec > 0 {
+		return time.Unix(sec, 0), true
+	}
+
+	return time.Time{}, false
+}
@@ -0,0 +1,179 @@
+package otpauth
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+/*
+otpauth:// URL(Key Uri Format), ex. as returned by 'multiotp -urllink user':
+otpauth://totp/multiOTP:<NAME>%20<SURNANME>?secret=<BASE32 SEED>&digits=6&period=30
+*/
+
+// Token types
+const (
+	TOTP = "totp"
+	HOTP = "hotp"
+)
+
+// Defaults of optional parameters
+const (
+	DefaultAlgorithm = "SHA1"
+	DefaultDigits    = 6
+	DefaultPeriod    = 30
+)
+
+// Key is parsed otpauth:// URL
+type Key struct {
+	Type      string // TOTP or HOTP
+	Issuer    string // 'issuer' parameter or label prefix
+	Account   string // label without issuer prefix
+	Secret    string // base32 secret
+	Algorithm string // SHA1, SHA256 or SHA512
+	Digits    int
+	Period    int        // seconds, TOTP only
+	Counter   uint64     // HOTP only
+	Image     string     // 'image' parameter: logo URL shown by some authenticators
+	Extra     url.Values // unknown parameters, kept as is
+}
+
+// Parse parses otpauth:// URL, missing optional parameters get defaults
+func Parse(rawURL string) (*Key, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "otpauth" {
+		return nil, fmt.Errorf("otpauth: scheme must be 'otpauth', got %q", u.Scheme)
+	}
+
+	key := &Key{
+		Type:      strings.ToLower(u.Host),
+		Algorithm: DefaultAlgorithm,
+		Digits:    DefaultDigits,
+	}
+	if key.Type != TOTP && key.Type != HOTP {
+		return nil, fmt.Errorf("otpauth: type must be 'totp' or 'hotp', got %q", u.Host)
+	}
+
+	// label: "issuer:account" or "account", split on unescaped colon only
+	// before unescaping: issuer & account may contain encoded colon(String escapes it)
+	label := strings.TrimPrefix(u.EscapedPath(), "/")
+	issuer, account, ok := strings.Cut(label, ":")
+	if !ok {
+		issuer, account = "", label
+	}
+	if key.Issuer, err = url.PathUnescape(issuer); err != nil {
+		return nil, fmt.Errorf("otpauth: bad label %q", label)
+	}
+	if key.Account, err = url.PathUnescape(account); err != nil {
+		return nil, fmt.Errorf("otpauth: bad label %q", label)
+	}
+	key.Issuer = strings.TrimSpace(key.Issuer)
+	key.Account = strings.TrimSpace(key.Account)
+
+	query := u.Query()
+	key.Secret = query.Get("secret")
+	if len(key.Secret) == 0 {
+		return nil, errors.New("otpauth: secret is missing")
+	}
+	// parameter wins over label prefix
+	if issuer := query.Get("issuer"); len(issuer) != 0 {
+		key.Issuer = issuer
+	}
+	if algorithm := query.Get("algorithm"); len(algorithm) != 0 {
+		key.Algorithm = strings.ToUpper(algorithm)
+	}
+	if digits := query.Get("digits"); len(digits) != 0 {
+		key.Digits, err = strconv.Atoi(digits)
+		if err != nil {
+			return nil, fmt.Errorf("otpauth: bad digits %q", digits)
+		}
+	}
+
+	switch key.Type {
+	case TOTP:
+		key.Period = DefaultPeriod
+		if period := query.Get("period"); len(period) != 0 {
+			key.Period, err = strconv.Atoi(period)
+			if err != nil {
+				return nil, fmt.Errorf("otpauth: bad period %q", period)
+			}
+		}
+	case HOTP:
+		if counter := query.Get("counter"); len(counter) != 0 {
+			key.Counter, err = strconv.ParseUint(counter, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("otpauth: bad counter %q", counter)
+			}
+		}
+	}
+
+	key.Image = query.Get("image")
+
+	// the rest is kept for String()
+	for _, name := range []string{"secret", "issuer", "algorithm", "digits", "period", "counter", "image"} {
+		query.Del(name)
+	}
+	if len(query) != 0 {
+		key.Extra = query
+	}
+
+	return key, nil
+}
+
+// String builds otpauth:// URL of key, Parse(key.String()) returns the same key.
+// Secret is written as is, issuer is written both as label prefix and as parameter.
+func (k *Key) String() string {
+	var b strings.Builder
+
+	b.WriteString("otpauth://" + k.Type + "/")
+	if len(k.Issuer) != 0 {
+		b.WriteString(escape(k.Issuer) + ":")
+	}
+	b.WriteString(escape(k.Account))
+
+	b.WriteString("?secret=" + escape(k.Secret))
+	if len(k.Issuer) != 0 {
+		b.WriteString("&issuer=" + escape(k.Issuer))
+	}
+	b.WriteString("&algorithm=" + escape(k.Algorithm))
+	b.WriteString("&digits=" + strconv.Itoa(k.Digits))
+	switch k.Type {
+	case TOTP:
+		b.WriteString("&period=" + strconv.Itoa(k.Period))
+	case HOTP:
+		b.WriteString("&counter=" + strconv.FormatUint(k.Counter, 10))
+	}
+	if len(k.Image) != 0 {
+		b.WriteString("&image=" + escape(k.Image))
+	}
+	if len(k.Extra) != 0 {
+		// Encode escapes space as '+', authenticators expect '%20'
+		b.WriteString("&" + strings.ReplaceAll(k.Extra.Encode(), "+", "%20"))
+	}
+
+	return b.String()
+}
+
+// Escape label part or parameter value: space is '%20'(not '+'), colon is escaped
+func escape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// Format base32 secret for manual entry: upper case, no padding, grouped by 4 chars
+func FormatSecret(secret string) string {
+	secret = strings.ToUpper(strings.TrimRight(strings.ReplaceAll(secret, " ", ""), "="))
+
+	var b strings.Builder
+	for i, r := range secret {
+		if i > 0 && i%4 == 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,121 @@
+package otpauth
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		key  Key
+	}{
+		{
+			name: "totp",
+			key:  Key{Type: TOTP, Issuer: "ACME", Account: "jsmith", Secret: "JBSWY3DPEHPK3PXP", Algorithm: "SHA1", Digits: 6, Period: 30},
+		},
+		{
+			name: "hotp",
+			key:  Key{Type: HOTP, Issuer: "ACME", Account: "jsmith", Secret: "JBSWY3DPEHPK3PXP", Algorithm: "SHA512", Digits: 8, Counter: 42},
+		},
+		{
+			name: "no issuer",
+			key:  Key{Type: TOTP, Account: "John Smith", Secret: "JBSWY3DPEHPK3PXP", Algorithm: "SHA1", Digits: 6, Period: 30},
+		},
+		{
+			// encoded colon isn't issuer separator
+			name: "colon in account without issuer",
+			key:  Key{Type: TOTP, Account: "a:b", Secret: "JBSWY3DPEHPK3PXP", Algorithm: "SHA1", Digits: 6, Period: 30},
+		},
+		{
+			name: "colons in issuer & account",
+			key:  Key{Type: TOTP, Issuer: "ACME:EU", Account: "corp:jsmith", Secret: "JBSWY3DPEHPK3PXP", Algorithm: "SHA256", Digits: 6, Period: 60},
+		},
+		{
+			name: "special chars",
+			key:  Key{Type: TOTP, Issuer: "A&B Co", Account: "j.smith+otp@example.com", Secret: "JBSWY3DPEHPK3PXP", Algorithm: "SHA1", Digits: 6, Period: 30},
+		},
+		{
+			name: "image & extra",
+			key: Key{Type: TOTP, Issuer: "ACME", Account: "jsmith", Secret: "JBSWY3DPEHPK3PXP", Algorithm: "SHA1", Digits: 6, Period: 30,
+				Image: "https://acme.example.com/logo.png?size=64", Extra: url.Values{"color": {"dark blue"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rawURL := tt.key.String()
+			got, err := Parse(rawURL)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", rawURL, err)
+			}
+			if !reflect.DeepEqual(*got, tt.key) {
+				t.Errorf("Parse(%q) = %+v, want %+v", rawURL, *got, tt.key)
+			}
+			if again := got.String(); again != rawURL {
+				t.Errorf("String() = %q, want %q", again, rawURL)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		rawURL  string
+		issuer  string
+		account string
+	}{
+		// MultiOTP's URL
+		{"otpauth://totp/multiOTP:John%20Smith?secret=JBSWY3DPEHPK3PXP&digits=6&period=30", "multiOTP", "John Smith"},
+		// issuer parameter wins over label prefix
+		{"otpauth://totp/multiOTP:jsmith?secret=JBSWY3DPEHPK3PXP&issuer=ACME", "ACME", "jsmith"},
+		{"otpauth://totp/a%3Ab?secret=JBSWY3DPEHPK3PXP", "", "a:b"},
+	}
+
+	for _, tt := range tests {
+		key, err := Parse(tt.rawURL)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.rawURL, err)
+			continue
+		}
+		if key.Issuer != tt.issuer || key.Account != tt.account {
+			t.Errorf("Parse(%q) = %q, %q; want %q, %q", tt.rawURL, key.Issuer, key.Account, tt.issuer, tt.account)
+		}
+	}
+
+	for _, rawURL := range []string{
+		"https://example.com/?secret=JBSWY3DPEHPK3PXP",
+		"otpauth://motp/jsmith?secret=JBSWY3DPEHPK3PXP",
+		"otpauth://totp/jsmith?digits=6",
+		"otpauth://totp/jsmith?secret=JBSWY3DPEHPK3PXP&digits=six",
+	} {
+		if _, err := Parse(rawURL); err == nil {
+			t.Errorf("Parse(%q) must fail", rawURL)
+		}
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	rw, err := NewRewrite("ACME", "{{.Company}}:{{.sAMAccountName}}", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := Parse("otpauth://totp/multiOTP:John%20Smith?secret=JBSWY3DPEHPK3PXP&digits=6&period=30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rw.Apply(key, LabelData{LabelSAMAccountName: "jsmith"}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "otpauth://totp/ACME:jsmith?secret=JBSWY3DPEHPK3PXP&issuer=ACME&algorithm=SHA1&digits=6&period=30"
+	if got := key.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := NewRewrite("", "{{.Unknown}}", ""); err == nil {
+		t.Error("unknown label field must fail")
+	}
+}
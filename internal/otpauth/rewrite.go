@@ -0,0 +1,118 @@
+package otpauth
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"text/template"
+)
+
+/*
+Rewrite changes issuer, label & image of key before showing it to user,
+ex. MultiOTP's "multiOTP:<NAME> <SURNAME>" label -> "ACME:jsmith".
+Label template(text/template) gets LabelData fields:
+{{.Company}}:{{.sAMAccountName}}
+*/
+type Rewrite struct {
+	issuer string             // empty - keep key's issuer
+	label  *template.Template // nil - keep key's label
+	image  string             // empty - keep key's image
+}
+
+// Label template data
+const (
+	LabelCompany        = "Company"        // rewritten issuer(or key's one if issuer isn't set)
+	LabelAccount        = "Account"        // key's account(ex. "<NAME> <SURNAME>" of MultiOTP)
+	LabelSAMAccountName = "sAMAccountName" // MultiOTP user
+	LabelDisplayName    = "DisplayName"    // user's display name(or login if it's empty)
+)
+
+// LabelData is label template data, keys are Label* consts
+type LabelData map[string]string
+
+// NewRewrite parses label template and checks image URL, all values are optional
+func NewRewrite(issuer, label, image string) (*Rewrite, error) {
+	rw := &Rewrite{
+		issuer: strings.TrimSpace(issuer),
+		image:  strings.TrimSpace(image),
+	}
+	if strings.Contains(rw.issuer, ":") {
+		return nil, fmt.Errorf("otpauth: issuer %q must not contain ':'", rw.issuer)
+	}
+
+	if len(rw.image) != 0 {
+		u, err := url.Parse(rw.image)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || len(u.Host) == 0 {
+			return nil, fmt.Errorf("otpauth: image must be 'http(s)://' URL, got %q", rw.image)
+		}
+	}
+
+	if len(strings.TrimSpace(label)) != 0 {
+		tmpl, err := template.New("label").Option("missingkey=error").Parse(label)
+		if err != nil {
+			return nil, fmt.Errorf("otpauth: bad label template: %w", err)
+		}
+		rw.label = tmpl
+
+		// unknown fields are found now, not on user's request
+		sample := LabelData{LabelCompany: "company", LabelAccount: "account", LabelSAMAccountName: "user", LabelDisplayName: "User"}
+		if _, _, err := rw.render(sample); err != nil {
+			return nil, err
+		}
+	}
+
+	return rw, nil
+}
+
+// Apply rewrites key's issuer, label & image. Secret & other parameters are kept.
+// data may omit Company & Account, they are taken from key.
+func (rw *Rewrite) Apply(key *Key, data LabelData) error {
+	if len(rw.issuer) != 0 {
+		key.Issuer = rw.issuer
+	}
+	if len(rw.image) != 0 {
+		key.Image = rw.image
+	}
+	if rw.label == nil {
+		return nil
+	}
+
+	values := LabelData{LabelCompany: key.Issuer, LabelAccount: key.Account}
+	for name, value := range data {
+		values[name] = value
+	}
+	issuer, account, err := rw.render(values)
+	if err != nil {
+		return err
+	}
+
+	// label prefix must match issuer parameter
+	if len(issuer) != 0 {
+		key.Issuer = issuer
+	}
+	key.Account = account
+
+	return nil
+}
+
+// Render label template, "issuer:account" is split
+func (rw *Rewrite) render(data LabelData) (issuer, account string, err error) {
+	var buf bytes.Buffer
+	if err := rw.label.Execute(&buf, data); err != nil {
+		return "", "", fmt.Errorf("otpauth: failed to render label: %w", err)
+	}
+
+	label := strings.TrimSpace(buf.String())
+	if prefix, rest, ok := strings.Cut(label, ":"); ok {
+		issuer, account = strings.TrimSpace(prefix), strings.TrimSpace(rest)
+	} else {
+		account = label
+	}
+	if len(account) == 0 {
+		return "", "", errors.New("otpauth: label account is empty")
+	}
+
+	return issuer, account, nil
+}
@@ -0,0 +1,98 @@
+package qrwork
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg"
+	"image/png"
+	"os"
+)
+
+// Logo is company logo shown in the middle of QR
+type Logo struct {
+	img     image.Image
+	dataURI string // png data URI for svg
+}
+
+// Load logo from PNG or JPEG file
+func LoadLogo(path string) (*Logo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode logo %q: %w", path, err)
+	}
+	if img.Bounds().Empty() {
+		return nil, fmt.Errorf("empty logo %q", path)
+	}
+
+	// svg gets re-encoded png: JPEG is supported as well, content type is known
+	var buf bytes.Buffer
+	err = png.Encode(&buf, img)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode logo %q: %w", path, err)
+	}
+
+	return &Logo{
+		img:     img,
+		dataURI: "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()),
+	}, nil
+}
+
+// Position and side(modules) of light square under logo in QR of size modules.
+// Square takes about a quarter of QR side, High error correction restores hidden modules.
+func logoBox(size int) (pos, side int) {
+	side = size / 4
+	// centered exactly
+	if (size-side)%2 != 0 {
+		side++
+	}
+	return (size - side) / 2, side
+}
+
+// Logo svg elements: light square and image inside it(one module margin)
+func (l *Logo) svg(size int, opts Options) string {
+	pos, side := logoBox(size)
+	offset := (opts.QuietZone + pos) * opts.Scale
+
+	return fmt.Sprintf("\t<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"%s\"/>\n"+
+		"\t<image x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" preserveAspectRatio=\"xMidYMid meet\" href=\"%s\"/>\n",
+		offset, offset, side*opts.Scale, side*opts.Scale, opts.Light,
+		offset+opts.Scale, offset+opts.Scale, (side-2)*opts.Scale, (side-2)*opts.Scale, l.dataURI)
+}
+
+// Draw logo over QR image: light square and logo inside it(one module margin),
+// logo is scaled(nearest neighbour) keeping aspect ratio
+func (l *Logo) draw(img *image.RGBA, size, scale, quietZone int, light color.RGBA) {
+	pos, side := logoBox(size)
+	offset := (quietZone + pos) * scale
+	square := image.Rect(offset, offset, offset+side*scale, offset+side*scale)
+	draw.Draw(img, square, image.NewUniform(light), image.Point{}, draw.Src)
+
+	box := square.Inset(scale)
+	src := l.img.Bounds()
+	width, height := box.Dx(), box.Dy()
+	if src.Dx()*height > src.Dy()*width {
+		height = max(src.Dy()*width/src.Dx(), 1)
+	} else {
+		width = max(src.Dx()*height/src.Dy(), 1)
+	}
+
+	scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			scaled.Set(x, y, l.img.At(src.Min.X+x*src.Dx()/width, src.Min.Y+y*src.Dy()/height))
+		}
+	}
+
+	at := box.Min.Add(image.Pt((box.Dx()-width)/2, (box.Dy()-height)/2))
+	draw.Draw(img, scaled.Bounds().Add(at), scaled, image.Point{}, draw.Over)
+}
@@ -2,41 +2,210 @@ package qrwork
 
 import (
 	"bytes"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
 
 	go_qr "github.com/piglig/go-qr"
 )
 
+// error correction levels of QR(share of codewords that can be restored)
+const (
+	ECCLow      = "low"      // 7%
+	ECCMedium   = "medium"   // 15%
+	ECCQuartile = "quartile" // 25%
+	ECCHigh     = "high"     // 30%
+)
+
+// all supported error correction levels
+var ECCLevels = []string{ECCLow, ECCMedium, ECCQuartile, ECCHigh}
+
+// PNG size(px) limits
+const (
+	MinPNGSize = 100
+	MaxPNGSize = 2000
+)
+
+// Parse error correction level name(case insensitive)
+func ParseECC(level string) (go_qr.Ecc, error) {
+	switch strings.ToLower(level) {
+	case ECCLow:
+		return go_qr.Low, nil
+	case ECCMedium:
+		return go_qr.Medium, nil
+	case ECCQuartile:
+		return go_qr.Quartile, nil
+	case ECCHigh:
+		return go_qr.High, nil
+	}
+	return go_qr.Low, fmt.Errorf("unknown error correction level %q, must be one of %q", level, ECCLevels)
+}
+
+// Parse "#RRGGBB" color
+func ParseColor(value string) (color.RGBA, error) {
+	rgb, err := hex.DecodeString(strings.TrimPrefix(value, "#"))
+	if err != nil || !strings.HasPrefix(value, "#") || len(rgb) != 3 {
+		return color.RGBA{}, fmt.Errorf("invalid color %q, must be '#RRGGBB'", value)
+	}
+	return color.RGBA{R: rgb[0], G: rgb[1], B: rgb[2], A: 0xFF}, nil
+}
+
+// QR rendering options
+type Options struct {
+	ECC       string // error correction level(see ECCLevels), always High if Logo is set
+	Scale     int    // svg units per module
+	QuietZone int    // border around QR(modules)
+	Dark      string // color of dark modules, "#RRGGBB"
+	Light     string // color of light modules & quiet zone, "#RRGGBB"
+	Logo      *Logo  // shown in the middle of QR, nil - no logo
+}
+
+// Default options: black on white, as QR scanners expect
+func DefaultOptions() Options {
+	return Options{
+		ECC:       ECCLow,
+		Scale:     10,
+		QuietZone: 4,
+		Dark:      "#000000",
+		Light:     "#FFFFFF",
+	}
+}
+
+// Validate checks options, all problems are returned at once
+func (o Options) Validate() error {
+	var errs []error
+
+	if _, err := ParseECC(o.ECC); err != nil {
+		errs = append(errs, err)
+	}
+	if o.Scale < 1 || o.Scale > 100 {
+		errs = append(errs, fmt.Errorf("scale must be in 1..100, got %d", o.Scale))
+	}
+	if o.QuietZone < 0 || o.QuietZone > 20 {
+		errs = append(errs, fmt.Errorf("quiet zone must be in 0..20 modules, got %d", o.QuietZone))
+	}
+	dark, errDark := ParseColor(o.Dark)
+	if errDark != nil {
+		errs = append(errs, fmt.Errorf("dark: %w", errDark))
+	}
+	light, errLight := ParseColor(o.Light)
+	if errLight != nil {
+		errs = append(errs, fmt.Errorf("light: %w", errLight))
+	}
+	if errDark == nil && errLight == nil && dark == light {
+		errs = append(errs, errors.New("dark and light colors must differ"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Encode text with options' error correction level
+func (o Options) encode(text string) (*go_qr.QrCode, error) {
+	ecc, err := ParseECC(o.ECC)
+	if err != nil {
+		return nil, err
+	}
+	// logo hides some modules, max error correction is needed to restore them
+	if o.Logo != nil {
+		ecc = go_qr.High
+	}
+
+	return go_qr.EncodeText(text, ecc)
+}
+
 // Generate QR svg file and return string value of <svg> code
-func GenerateTOTPSvgQrHTML(totpURL []byte) (string, error) {
+func GenerateTOTPSvgQrHTML(totpURL []byte, opts Options) (string, error) {
 	var (
 		buf    bytes.Buffer
 		result string
 	)
 
 	// Encode & Generate QR
-	errCorLvl := go_qr.Low
-	qr, err := go_qr.EncodeText(string(totpURL), errCorLvl)
+	qr, err := opts.encode(string(totpURL))
 	if err != nil {
 		return result, err
 	}
-	config := go_qr.NewQrCodeImgConfig(10, 4)
+	// go-qr svg border is in svg units, not in modules
+	config := go_qr.NewQrCodeImgConfig(opts.Scale, opts.QuietZone*opts.Scale)
 
 	// write svg code to buffer
-	err = qr.WriteAsSVG(config, &buf, "#FFFFFF", "#000000")
+	err = qr.WriteAsSVG(config, &buf, opts.Light, opts.Dark)
 	if err != nil {
 		return result, err
 	}
 
-	// read from buffer
-	data := make([]byte, buf.Len())
-	buf.Read(data)
-
 	// set result and check if empty
-	result = string(data)
+	result = buf.String()
 	if len(result) == 0 {
 		return result, fmt.Errorf("empty result of generating svg")
 	}
 
+	// logo goes on top of modules, before closing tag
+	if opts.Logo != nil {
+		result = strings.TrimSpace(result)
+		result = strings.TrimSuffix(result, "</svg>") + opts.Logo.svg(qr.GetSize(), opts) + "</svg>\n"
+	}
+
 	return result, nil
 }
+
+// Generate QR png image of totpURL.
+// Image is scaled to the largest whole number of pixels per module fitting into size(px),
+// but it's never smaller than one pixel per module. opts.Scale isn't used.
+func GenerateTOTPPng(totpURL []byte, size int, opts Options) ([]byte, error) {
+	if size < MinPNGSize || size > MaxPNGSize {
+		return nil, fmt.Errorf("png size must be in %d..%d, got %d", MinPNGSize, MaxPNGSize, size)
+	}
+	dark, err := ParseColor(opts.Dark)
+	if err != nil {
+		return nil, err
+	}
+	light, err := ParseColor(opts.Light)
+	if err != nil {
+		return nil, err
+	}
+
+	// Encode & Generate QR
+	qr, err := opts.encode(string(totpURL))
+	if err != nil {
+		return nil, err
+	}
+	scale := max(size/(qr.GetSize()+2*opts.QuietZone), 1)
+
+	img := toImage(qr, scale, opts.QuietZone, dark, light)
+	if opts.Logo != nil {
+		opts.Logo.draw(img, qr.GetSize(), scale, opts.QuietZone, light)
+	}
+
+	var buf bytes.Buffer
+	err = png.Encode(&buf, img)
+	if err != nil {
+		return nil, err
+	}
+	if buf.Len() == 0 {
+		return nil, fmt.Errorf("empty result of generating png")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Draw QR modules: scale px per module, quietZone modules around
+func toImage(qr *go_qr.QrCode, scale, quietZone int, dark, light color.RGBA) *image.RGBA {
+	side := (qr.GetSize() + 2*quietZone) * scale
+	img := image.NewRGBA(image.Rect(0, 0, side, side))
+	for y := 0; y < side; y++ {
+		for x := 0; x < side; x++ {
+			// GetModule is false(light) outside of QR
+			if qr.GetModule(x/scale-quietZone, y/scale-quietZone) {
+				img.SetRGBA(x, y, dark)
+			} else {
+				img.SetRGBA(x, y, light)
+			}
+		}
+	}
+	return img
+}
@@ -0,0 +1,252 @@
+package qrwork
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/qrcode"
+)
+
+// go test ./internal/qrwork -update rewrites golden files
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+const testURL = "otpauth://totp/ACME:jsmith?secret=JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP&issuer=ACME&algorithm=SHA1&digits=6&period=30"
+
+func TestGolden(t *testing.T) {
+	logo, err := LoadLogo(filepath.Join("testdata", "acme-logo.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	colors := DefaultOptions()
+	colors.Dark, colors.Light = "#1F3A93", "#FFF8E7"
+
+	quietZone := DefaultOptions()
+	quietZone.QuietZone = 8
+
+	withLogo := DefaultOptions()
+	withLogo.Logo = logo
+
+	tests := []struct {
+		name string
+		opts Options
+		ecc  string // decoded error correction level
+	}{
+		{name: "default", opts: DefaultOptions(), ecc: "L"},
+		{name: "colors", opts: colors, ecc: "L"},
+		{name: "quiet-zone", opts: quietZone, ecc: "L"},
+		// ECC "low" is raised because of logo
+		{name: "logo", opts: withLogo, ecc: "H"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svg, err := GenerateTOTPSvgQrHTML([]byte(testURL), tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden(t, tt.name+".svg", []byte(svg))
+
+			text, ecc := decodeImage(t, rasterize(t, svg))
+			if text != testURL {
+				t.Errorf("svg: decoded %q, want %q", text, testURL)
+			}
+			if ecc != tt.ecc {
+				t.Errorf("svg: error correction level %q, want %q", ecc, tt.ecc)
+			}
+
+			pngData, err := GenerateTOTPPng([]byte(testURL), 300, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden(t, tt.name+".png", pngData)
+
+			img, err := png.Decode(bytes.NewReader(pngData))
+			if err != nil {
+				t.Fatal(err)
+			}
+			text, ecc = decodeImage(t, img)
+			if text != testURL {
+				t.Errorf("png: decoded %q, want %q", text, testURL)
+			}
+			if ecc != tt.ecc {
+				t.Errorf("png: error correction level %q, want %q", ecc, tt.ecc)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := DefaultOptions().Validate(); err != nil {
+		t.Errorf("default options: %v", err)
+	}
+
+	bad := DefaultOptions()
+	bad.ECC = "max"
+	bad.Dark = "#FFFFFF"
+	if err := bad.Validate(); err == nil {
+		t.Error("unknown ECC and same colors must fail")
+	}
+}
+
+// Compare data with golden file, rewrite it with -update
+func golden(t *testing.T, name string, data []byte) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v(run with -update to create golden files)", err)
+	}
+	if !bytes.Equal(data, want) {
+		t.Errorf("%s differs from golden file", name)
+	}
+}
+
+// Decode QR image, return text & error correction level
+func decodeImage(t *testing.T, img image.Image) (string, string) {
+	t.Helper()
+
+	bmp, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := qrcode.NewQRCodeReader().Decode(bmp, map[gozxing.DecodeHintType]interface{}{
+		gozxing.DecodeHintType_TRY_HARDER: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to decode QR: %v", err)
+	}
+
+	ecc, _ := result.GetResultMetadata()[gozxing.ResultMetadataType_ERROR_CORRECTION_LEVEL].(string)
+	return result.GetText(), ecc
+}
+
+// module square of go-qr svg path: "Mx,yhNvNh-Nz"
+var svgModule = regexp.MustCompile(`^M(\d+),(\d+)h(\d+)v(\d+)h-\d+z$`)
+
+// Rasterize QR svg(1px per svg unit) in document order: <rect>s, module <path>
+// and logo <image>(png data URI, scaled keeping aspect ratio), other elements fail
+func rasterize(t *testing.T, svg string) image.Image {
+	t.Helper()
+
+	var img *image.RGBA
+	fill := func(r image.Rectangle, value string) {
+		c, err := ParseColor(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		draw.Draw(img, r, image.NewUniform(c), image.Point{}, draw.Src)
+	}
+
+	dec := xml.NewDecoder(strings.NewReader(svg))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		el, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		attrs := make(map[string]string)
+		for _, attr := range el.Attr {
+			attrs[attr.Name.Local] = attr.Value
+		}
+		num := func(name string) int {
+			n, err := strconv.Atoi(attrs[name])
+			if err != nil && len(attrs[name]) != 0 {
+				t.Fatalf("<%s %s=%q>: %v", el.Name.Local, name, attrs[name], err)
+			}
+			return n
+		}
+
+		switch el.Name.Local {
+		case "svg":
+			var side int
+			if _, err := fmt.Sscanf(attrs["viewBox"], "0 0 %d %d", &side, &side); err != nil {
+				t.Fatalf("viewBox %q: %v", attrs["viewBox"], err)
+			}
+			img = image.NewRGBA(image.Rect(0, 0, side, side))
+		case "rect":
+			fill(image.Rect(num("x"), num("y"), num("x")+num("width"), num("y")+num("height")), attrs["fill"])
+		case "path":
+			for _, m := range strings.Fields(attrs["d"]) {
+				sm := svgModule.FindStringSubmatch(m)
+				if sm == nil {
+					t.Fatalf("unexpected path command %q", m)
+				}
+				x, _ := strconv.Atoi(sm[1])
+				y, _ := strconv.Atoi(sm[2])
+				w, _ := strconv.Atoi(sm[3])
+				h, _ := strconv.Atoi(sm[4])
+				fill(image.Rect(x, y, x+w, y+h), attrs["fill"])
+			}
+		case "image":
+			data, ok := strings.CutPrefix(attrs["href"], "data:image/png;base64,")
+			if !ok {
+				t.Fatalf("<image> href isn't png data URI")
+			}
+			raw, err := base64.StdEncoding.DecodeString(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			logo, err := png.Decode(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			drawMeet(img, image.Rect(num("x"), num("y"), num("x")+num("width"), num("y")+num("height")), logo)
+		default:
+			t.Fatalf("unexpected svg element <%s>", el.Name.Local)
+		}
+	}
+
+	if img == nil {
+		t.Fatal("no <svg> element")
+	}
+	return img
+}
+
+// draw src into box centered and scaled keeping aspect ratio
+// (preserveAspectRatio="xMidYMid meet"), nearest neighbour
+func drawMeet(dst *image.RGBA, box image.Rectangle, src image.Image) {
+	b := src.Bounds()
+	width, height := box.Dx(), box.Dy()
+	if b.Dx()*height > b.Dy()*width {
+		height = max(b.Dy()*width/b.Dx(), 1)
+	} else {
+		width = max(b.Dx()*height/b.Dy(), 1)
+	}
+
+	at := box.Min.Add(image.Pt((box.Dx()-width)/2, (box.Dy()-height)/2))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := src.At(b.Min.X+x*b.Dx()/width, b.Min.Y+y*b.Dy()/height)
+			draw.Draw(dst, image.Rect(at.X+x, at.Y+y, at.X+x+1, at.Y+y+1), image.NewUniform(c), image.Point{}, draw.Over)
+		}
+	}
+}
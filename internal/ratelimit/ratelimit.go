@@ -0,0 +1,58 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter allows max attempts per key(ex. user) in sliding window.
+// Attempts are kept in memory only, expired ones are pruned on Allow.
+type Limiter struct {
+	mu       sync.Mutex
+	max      int
+	window   time.Duration
+	attempts map[string][]time.Time
+}
+
+// New returns Limiter allowing max attempts per key in window
+func New(max int, window time.Duration) *Limiter {
+	return &Limiter{
+		max:      max,
+		window:   window,
+		attempts: make(map[string][]time.Time),
+	}
+}
+
+// Allow records attempt of key and returns true if it's allowed,
+// otherwise returns false and time to wait for the next allowed attempt
+// (rejected attempts aren't recorded)
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.prune(now)
+
+	attempts := l.attempts[key]
+	if len(attempts) >= l.max {
+		return false, attempts[0].Add(l.window).Sub(now)
+	}
+
+	l.attempts[key] = append(attempts, now)
+	return true, 0
+}
+
+// drop attempts out of window, must be called with l.mu held
+func (l *Limiter) prune(now time.Time) {
+	for key, attempts := range l.attempts {
+		i := 0
+		for i < len(attempts) && now.Sub(attempts[i]) >= l.window {
+			i++
+		}
+		if i == len(attempts) {
+			delete(l.attempts, key)
+			continue
+		}
+		l.attempts[key] = attempts[i:]
+	}
+}
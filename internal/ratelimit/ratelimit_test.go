@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	const window = 200 * time.Millisecond
+	l := New(2, window)
+
+	for i := range 2 {
+		if ok, wait := l.Allow("alice"); !ok || wait != 0 {
+			t.Errorf("attempt %d = %t, %s; want allowed", i+1, ok, wait)
+		}
+		if i == 0 {
+			time.Sleep(window / 2)
+		}
+	}
+
+	ok, wait := l.Allow("alice")
+	if ok {
+		t.Fatal("attempt over max must be rejected")
+	}
+	// wait is till the first attempt expires
+	if wait <= 0 || wait > window/2 {
+		t.Errorf("wait %s, want (0, %s]", wait, window/2)
+	}
+	if ok, _ := l.Allow("bob"); !ok {
+		t.Error("other key's attempt must be allowed")
+	}
+
+	// the first attempt expires, the second one is in window, rejected one isn't recorded:
+	// one more is allowed
+	time.Sleep(wait)
+	if ok, _ := l.Allow("alice"); !ok {
+		t.Error("attempt after wait must be allowed")
+	}
+	if ok, _ := l.Allow("alice"); ok {
+		t.Error("attempts in window must be capped again")
+	}
+}
+
+// expired attempts are pruned, keys without attempts are dropped
+func TestLimiterPrune(t *testing.T) {
+	const window = 50 * time.Millisecond
+	l := New(1, window)
+
+	l.Allow("alice")
+	l.Allow("bob")
+	time.Sleep(window)
+	l.Allow("bob")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, found := l.attempts["alice"]; found {
+		t.Error("expired key isn't dropped")
+	}
+	if n := len(l.attempts["bob"]); n != 1 {
+		t.Errorf("%d attempts of bob, want 1", n)
+	}
+}
@@ -0,0 +1,134 @@
+package secrets
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// what secrets are replaced with in logs
+const redacted = "[REDACTED]"
+
+// MinRedactLength is min length of secret hidden inside other text.
+// Shorter secrets(ex. PIN "1234" or "admin") would hide unrelated
+// parts of logs(numbers, words), they are hidden as whole values only.
+const MinRedactLength = 8
+
+// Redactor holds known secret values to hide them in logs.
+// Safe for concurrent use.
+type Redactor struct {
+	mu       sync.RWMutex
+	values   map[string]struct{}
+	short    map[string]struct{}
+	replacer *strings.Replacer
+}
+
+// NewRedactor makes empty Redactor
+func NewRedactor() *Redactor {
+	return &Redactor{values: make(map[string]struct{}), short: make(map[string]struct{})}
+}
+
+// Add secret values to hide, empty values are skipped,
+// values shorter than MinRedactLength are hidden as whole values only
+func (r *Redactor) Add(values ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, value := range values {
+		switch {
+		case len(value) >= MinRedactLength:
+			r.values[value] = struct{}{}
+		case len(value) != 0:
+			r.short[value] = struct{}{}
+		}
+	}
+
+	// longer values first, so secret containing another one is hidden fully
+	sorted := slices.SortedFunc(maps.Keys(r.values), func(a, b string) int {
+		return len(b) - len(a)
+	})
+
+	pairs := make([]string, 0, 2*len(sorted))
+	for _, value := range sorted {
+		pairs = append(pairs, value, redacted)
+	}
+	r.replacer = strings.NewReplacer(pairs...)
+}
+
+// Redact replaces all known secrets in s,
+// short secrets are replaced only if s is the secret itself
+func (r *Redactor) Redact(s string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if _, ok := r.short[s]; ok {
+		return redacted
+	}
+	if r.replacer == nil {
+		return s
+	}
+
+	return r.replacer.Replace(s)
+}
+
+// RedactHandler is slog.Handler wrapper which hides secrets
+// in message and all attributes' values(including groups and errors)
+type RedactHandler struct {
+	next     slog.Handler
+	redactor *Redactor
+}
+
+// NewRedactHandler wraps next handler
+func NewRedactHandler(next slog.Handler, redactor *Redactor) *RedactHandler {
+	return &RedactHandler{next: next, redactor: redactor}
+}
+
+func (h *RedactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *RedactHandler) Handle(ctx context.Context, record slog.Record) error {
+	clean := slog.NewRecord(record.Time, record.Level, h.redactor.Redact(record.Message), record.PC)
+	record.Attrs(func(a slog.Attr) bool {
+		clean.AddAttrs(h.redactAttr(a))
+		return true
+	})
+
+	return h.next.Handle(ctx, clean)
+}
+
+func (h *RedactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clean := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		clean = append(clean, h.redactAttr(a))
+	}
+
+	return &RedactHandler{next: h.next.WithAttrs(clean), redactor: h.redactor}
+}
+
+func (h *RedactHandler) WithGroup(name string) slog.Handler {
+	return &RedactHandler{next: h.next.WithGroup(name), redactor: h.redactor}
+}
+
+// all non-group values are logged as strings after redaction
+func (h *RedactHandler) redactAttr(a slog.Attr) slog.Attr {
+	value := a.Value.Resolve()
+
+	switch value.Kind() {
+	case slog.KindGroup:
+		group := value.Group()
+		clean := make([]any, 0, len(group))
+		for _, ga := range group {
+			clean = append(clean, h.redactAttr(ga))
+		}
+		return slog.Group(a.Key, clean...)
+	case slog.KindString, slog.KindAny:
+		return slog.String(a.Key, h.redactor.Redact(value.String()))
+	default:
+		// numbers, bools, time - can't hold secrets
+		return slog.Attr{Key: a.Key, Value: value}
+	}
+}
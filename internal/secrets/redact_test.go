@@ -0,0 +1,59 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRedactHandler(t *testing.T) {
+	r := NewRedactor()
+	r.Add("db-pa$$word", "bind-secret-pass", "")
+
+	var buf bytes.Buffer
+	logger := slog.New(NewRedactHandler(slog.NewJSONHandler(&buf, nil), r))
+	logger.With("dsn", "user:db-pa$$word@tcp(db)").WithGroup("ldap").Info("bind with bind-secret-pass",
+		"error", errors.New("bind failed for bind-secret-pass"),
+		slog.Group("conn", "pass", "db-pa$$word"),
+		"attempts", 3,
+	)
+
+	out := buf.String()
+	for _, secret := range []string{"db-pa$$word", "bind-secret-pass"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("log contains secret %q: %s", secret, out)
+		}
+	}
+	if strings.Count(out, redacted) != 4 || !strings.Contains(out, `"attempts":3`) {
+		t.Errorf("unexpected log: %s", out)
+	}
+}
+
+// short secrets hide only whole values, not parts of other text
+func TestRedactorMinLength(t *testing.T) {
+	r := NewRedactor()
+	r.Add("1234", "admin", "long-secret-value")
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"1234", redacted},
+		{"admin", redacted},
+		{"port 12345, user administrator", "port 12345, user administrator"},
+		{"token long-secret-value used", "token " + redacted + " used"},
+	}
+	for _, tt := range tests {
+		if got := r.Redact(tt.in); got != tt.want {
+			t.Errorf("Redact(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	var buf bytes.Buffer
+	slog.New(NewRedactHandler(slog.NewTextHandler(&buf, nil), r)).Info("listening on port 1234", "pin", "1234")
+	if out := buf.String(); !strings.Contains(out, "port 1234") || !strings.Contains(out, "pin="+redacted) {
+		t.Errorf("short secret: unexpected log %s", out)
+	}
+}
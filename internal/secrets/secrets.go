@@ -0,0 +1,113 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+/*
+Secret config value may be a reference:
+	env:NAME          - value of env var NAME
+	file:/path        - content of file(ex. docker/k8s secret in /run/secrets/x)
+	exec:/path/helper - stdout of helper program
+	store:NAME        - NAME from local encrypted secrets file(see store.go)
+Any other value is used as is(plain text).
+Trailing new lines are trimmed for file: and exec: values.
+*/
+
+// time limit for exec: helpers
+const execTimeout = 10 * time.Second
+
+// Resolver resolves secret references.
+// Encrypted store is decrypted only if store: reference is used.
+type Resolver struct {
+	storePath string
+	masterKey string
+	store     map[string]string
+}
+
+// NewResolver makes Resolver, storePath and masterKey may be empty
+// if store: references aren't used
+func NewResolver(storePath, masterKey string) *Resolver {
+	return &Resolver{
+		storePath: storePath,
+		masterKey: masterKey,
+	}
+}
+
+// IsReference returns true if value is a secret reference, not plain text
+func IsReference(value string) bool {
+	for _, prefix := range []string{"env:", "file:", "exec:", "store:"} {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Resolve returns secret value of reference(or value itself if it's not a reference).
+// Errors never contain secret values.
+func (r *Resolver) Resolve(value string) (string, error) {
+	kind, ref, found := strings.Cut(value, ":")
+	if !found || !IsReference(value) {
+		return value, nil
+	}
+
+	if len(ref) == 0 {
+		return "", fmt.Errorf("empty %s: reference", kind)
+	}
+
+	switch kind {
+	case "env":
+		secret, ok := os.LookupEnv(ref)
+		if !ok {
+			return "", fmt.Errorf("env %s is not set", ref)
+		}
+		return secret, nil
+
+	case "file":
+		data, err := os.ReadFile(ref)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file: %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+
+	case "exec":
+		ctx, cancel := context.WithTimeout(context.Background(), execTimeout)
+		defer cancel()
+
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, ref)
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			// stderr of helper is not a secret, stdout may be
+			return "", fmt.Errorf("secret helper %s failed: %w; stderr: %s", ref, err, strings.TrimSpace(stderr.String()))
+		}
+		return strings.TrimRight(string(out), "\r\n"), nil
+
+	default: // store
+		if r.store == nil {
+			if len(r.storePath) == 0 {
+				return "", fmt.Errorf("store:%s is used, but secrets file is not set", ref)
+			}
+			store, err := ReadStore(r.storePath, r.masterKey)
+			if err != nil {
+				return "", err
+			}
+			r.store = store
+		}
+
+		secret, ok := r.store[ref]
+		if !ok {
+			return "", fmt.Errorf("secret %s not found in secrets file %s", ref, r.storePath)
+		}
+		return secret, nil
+	}
+}
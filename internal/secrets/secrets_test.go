@@ -0,0 +1,172 @@
+package secrets
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+const testMasterKey = "correct horse battery staple"
+
+func TestStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	want := map[string]string{"db": "db-pa$$word", "bind": "bind pass"}
+	if err := WriteStore(path, testMasterKey, want); err != nil {
+		t.Fatal(err)
+	}
+
+	// secrets aren't stored in plain text
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "db-pa$$word") {
+		t.Error("secrets file contains plain secret")
+	}
+
+	got, err := ReadStore(path, testMasterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) || got["db"] != want["db"] || got["bind"] != want["bind"] {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := ReadStore(path, "wrong master key"); err == nil || !strings.Contains(err.Error(), "wrong master key?") {
+		t.Errorf("wrong master key: got %v", err)
+	}
+	if _, err := ReadStore(path, ""); err == nil {
+		t.Error("empty master key must fail")
+	}
+}
+
+func TestStoreTampered(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	if err := WriteStore(path, testMasterKey, map[string]string{"db": "db-pa$$word"}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file storeFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]func(f *storeFile){
+		"ciphertext": func(f *storeFile) { f.Data[0] ^= 0xFF },
+		"nonce":      func(f *storeFile) { f.Nonce[0] ^= 0xFF },
+		"salt":       func(f *storeFile) { f.Salt[0] ^= 0xFF },
+		"nonce size": func(f *storeFile) { f.Nonce = f.Nonce[1:] },
+	}
+	for name, tamper := range tests {
+		f := storeFile{
+			Salt:  append([]byte(nil), file.Salt...),
+			Nonce: append([]byte(nil), file.Nonce...),
+			Data:  append([]byte(nil), file.Data...),
+		}
+		tamper(&f)
+		tampered, err := json.Marshal(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, tampered, 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := ReadStore(path, testMasterKey); err == nil {
+			t.Errorf("tampered %s must fail", name)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte("not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadStore(path, testMasterKey); err == nil || !strings.Contains(err.Error(), "damaged") {
+		t.Errorf("damaged file: got %v", err)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	dir := t.TempDir()
+
+	storePath := filepath.Join(dir, "secrets.json")
+	if err := WriteStore(storePath, testMasterKey, map[string]string{"db": "store-secret"}); err != nil {
+		t.Fatal(err)
+	}
+
+	secretFile := filepath.Join(dir, "secret")
+	if err := os.WriteFile(secretFile, []byte("file-secret\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("TEST_SECRET", "env-secret")
+
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"plain-secret", "plain-secret"},
+		{"env:TEST_SECRET", "env-secret"},
+		{"file:" + secretFile, "file-secret"},
+		{"store:db", "store-secret"},
+		// unknown prefix is plain text
+		{"vault:db", "vault:db"},
+	}
+
+	if runtime.GOOS != "windows" {
+		helper := filepath.Join(dir, "helper.sh")
+		if err := os.WriteFile(helper, []byte("#!/bin/sh\necho exec-secret\n"), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		tests = append(tests, struct {
+			value string
+			want  string
+		}{"exec:" + helper, "exec-secret"})
+	}
+
+	r := NewResolver(storePath, testMasterKey)
+	for _, tt := range tests {
+		got, err := r.Resolve(tt.value)
+		if err != nil || got != tt.want {
+			t.Errorf("Resolve(%q) = %q, %v; want %q", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+// errors never contain secrets
+func TestResolveErrors(t *testing.T) {
+	dir := t.TempDir()
+	storePath := filepath.Join(dir, "secrets.json")
+	if err := WriteStore(storePath, testMasterKey, map[string]string{"db": "store-secret"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		resolver *Resolver
+		value    string
+	}{
+		{NewResolver("", ""), "env:TEST_SECRET_NOT_SET"},
+		{NewResolver("", ""), "env:"},
+		{NewResolver("", ""), "file:" + filepath.Join(dir, "missing")},
+		{NewResolver("", ""), "exec:" + filepath.Join(dir, "missing")},
+		{NewResolver("", ""), "store:db"},
+		{NewResolver(storePath, "wrong master key"), "store:db"},
+		{NewResolver(storePath, testMasterKey), "store:missing"},
+	}
+
+	for _, tt := range tests {
+		_, err := tt.resolver.Resolve(tt.value)
+		if err == nil {
+			t.Errorf("Resolve(%q) must fail", tt.value)
+			continue
+		}
+		if strings.Contains(err.Error(), "store-secret") {
+			t.Errorf("Resolve(%q) error contains secret: %v", tt.value, err)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+/*
+Local encrypted secrets file(JSON):
+	{"salt": "<base64>", "nonce": "<base64>", "data": "<base64>"}
+data is AES-256-GCM encrypted JSON object {"NAME": "secret", ...},
+key is derived from master key with scrypt(salt).
+*/
+
+// scrypt params, recommended for interactive logins(2017)
+const (
+	scryptN   = 32768
+	scryptR   = 8
+	scryptP   = 1
+	keyLength = 32
+	saltSize  = 16
+)
+
+type storeFile struct {
+	Salt  []byte `json:"salt"`
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+// derive AES key from master key and make GCM
+func newGCM(masterKey string, salt []byte) (cipher.AEAD, error) {
+	if len(masterKey) == 0 {
+		return nil, errors.New("master key is empty")
+	}
+
+	key, err := scrypt.Key([]byte(masterKey), salt, scryptN, scryptR, scryptP, keyLength)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// ReadStore decrypts secrets file with master key
+func ReadStore(path, masterKey string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets file: %w", err)
+	}
+
+	var file storeFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("secrets file %s is damaged: %w", path, err)
+	}
+
+	gcm, err := newGCM(masterKey, file.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("secrets file %s: %w", path, err)
+	}
+
+	if len(file.Nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("secrets file %s is damaged: wrong nonce size", path)
+	}
+
+	plain, err := gcm.Open(nil, file.Nonce, file.Data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt secrets file %s(wrong master key?)", path)
+	}
+
+	store := make(map[string]string)
+	if err := json.Unmarshal(plain, &store); err != nil {
+		return nil, fmt.Errorf("secrets file %s: decrypted data is not a JSON object", path)
+	}
+
+	return store, nil
+}
+
+// WriteStore encrypts secrets with master key and writes secrets file
+func WriteStore(path, masterKey string, store map[string]string) error {
+	plain, err := json.Marshal(store)
+	if err != nil {
+		return err
+	}
+
+	file := storeFile{Salt: make([]byte, saltSize)}
+	if _, err := rand.Read(file.Salt); err != nil {
+		return err
+	}
+
+	gcm, err := newGCM(masterKey, file.Salt)
+	if err != nil {
+		return err
+	}
+
+	file.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return err
+	}
+	file.Data = gcm.Seal(nil, file.Nonce, plain, nil)
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
@@ -0,0 +1,47 @@
+package sessionstore
+
+import (
+	"log/slog"
+	"time"
+)
+
+// how often expired sessions are removed
+const cleanupInterval = 5 * time.Minute
+
+// queries to remove expired sessions, same as scs stores' cleanup
+var cleanupQueries = map[string]string{
+	MySQL:    `DELETE FROM sessions WHERE expiry < UTC_TIMESTAMP(6)`,
+	Postgres: `DELETE FROM sessions WHERE expiry < current_timestamp`,
+	SQLite:   `DELETE FROM sessions WHERE expiry < julianday('now')`,
+}
+
+// Start expired sessions cleanup of db store, returns func to stop it.
+// scs db stores log cleanup errors with std log, so their own cleanup
+// is disabled and done here with store's logger.
+func (s *Store) startCleanup(interval time.Duration) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := s.DB.Exec(cleanupQueries[s.kind]); err != nil {
+					s.logger.Error("failed to remove expired sessions", "sessionStore", s.kind, slog.Any("error", err))
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+	}
+}
@@ -0,0 +1,81 @@
+package sessionstore
+
+import (
+	"context"
+	"time"
+)
+
+/*
+Enrollment markers: user has confirmed their token with valid OTP,
+so its QR isn't shown anymore(until reissue).
+Markers are kept in 'enrollments' table of session db, Memory store keeps them in memory(lost on restart).
+*/
+
+type enrollmentQueries struct {
+	find   string
+	set    string
+	delete string
+}
+
+var enrollmentQueriesByKind = map[string]enrollmentQueries{
+	MySQL: {
+		find:   `SELECT COUNT(*) FROM enrollments WHERE user_name = ?`,
+		set:    `INSERT IGNORE INTO enrollments (user_name, enrolled_at) VALUES (?, ?)`,
+		delete: `DELETE FROM enrollments WHERE user_name = ?`,
+	},
+	Postgres: {
+		find:   `SELECT COUNT(*) FROM enrollments WHERE user_name = $1`,
+		set:    `INSERT INTO enrollments (user_name, enrolled_at) VALUES ($1, $2) ON CONFLICT (user_name) DO NOTHING`,
+		delete: `DELETE FROM enrollments WHERE user_name = $1`,
+	},
+	SQLite: {
+		find:   `SELECT COUNT(*) FROM enrollments WHERE user_name = $1`,
+		set:    `INSERT OR IGNORE INTO enrollments (user_name, enrolled_at) VALUES ($1, $2)`,
+		delete: `DELETE FROM enrollments WHERE user_name = $1`,
+	},
+}
+
+// Enrolled reports if user has confirmed enrollment
+func (s *Store) Enrolled(ctx context.Context, user string) (bool, error) {
+	if s.DB == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		return s.enrolled[user], nil
+	}
+
+	var count int
+	err := s.DB.QueryRowContext(ctx, enrollmentQueriesByKind[s.kind].find, user).Scan(&count)
+	s.setHealth(err)
+	return count != 0, err
+}
+
+// SetEnrolled saves enrollment marker of user, existing marker is kept
+func (s *Store) SetEnrolled(ctx context.Context, user string) error {
+	if s.DB == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.enrolled[user] = true
+		return nil
+	}
+
+	_, err := s.DB.ExecContext(ctx, enrollmentQueriesByKind[s.kind].set, user, time.Now().Unix())
+	s.setHealth(err)
+	return err
+}
+
+// ResetEnrolled removes enrollment marker of user(ex. on reissue: new QR is shown once again)
+func (s *Store) ResetEnrolled(ctx context.Context, user string) error {
+	if s.DB == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		delete(s.enrolled, user)
+		return nil
+	}
+
+	_, err := s.DB.ExecContext(ctx, enrollmentQueriesByKind[s.kind].delete, user)
+	s.setHealth(err)
+	return err
+}
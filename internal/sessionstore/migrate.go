@@ -0,0 +1,115 @@
+package sessionstore
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+)
+
+// Schema migrations per store kind, applied in order.
+// Applied version is kept in 'schema_migrations' table.
+// Never edit applied migrations - append new ones.
+// Migration 2: enrollment markers(see enrollment.go).
+// Migration 3: self-unlocks(see unlocks.go).
+// MySQL table is the same as in README's SQL script(created by hand before),
+// so migration 1 is safe for existing dbs.
+var migrations = map[string][]string{
+	MySQL: {
+		`CREATE TABLE IF NOT EXISTS sessions (
+			token CHAR(43) PRIMARY KEY,
+			data BLOB NOT NULL,
+			expiry TIMESTAMP(6) NOT NULL,
+			INDEX sessions_expiry_idx (expiry)
+		)`,
+		`CREATE TABLE IF NOT EXISTS enrollments (
+			user_name VARCHAR(255) PRIMARY KEY,
+			enrolled_at BIGINT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS self_unlocks (
+			user_name VARCHAR(255) NOT NULL,
+			unlocked_at BIGINT NOT NULL,
+			INDEX self_unlocks_user_idx (user_name)
+		)`,
+	},
+	Postgres: {
+		`CREATE TABLE IF NOT EXISTS sessions (
+			token TEXT PRIMARY KEY,
+			data BYTEA NOT NULL,
+			expiry TIMESTAMPTZ NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS sessions_expiry_idx ON sessions (expiry)`,
+		`CREATE TABLE IF NOT EXISTS enrollments (
+			user_name TEXT PRIMARY KEY,
+			enrolled_at BIGINT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS self_unlocks (
+			user_name VARCHAR(255) NOT NULL,
+			unlocked_at BIGINT NOT NULL,
+			INDEX self_unlocks_user_idx (user_name)
+		)`,
+	},
+	SQLite: {
+		`CREATE TABLE IF NOT EXISTS sessions (
+			token TEXT PRIMARY KEY,
+			data BLOB NOT NULL,
+			expiry REAL NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS sessions_expiry_idx ON sessions (expiry)`,
+		`CREATE TABLE IF NOT EXISTS enrollments (
+			user_name TEXT PRIMARY KEY,
+			enrolled_at INTEGER NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS self_unlocks (
+			user_name TEXT NOT NULL,
+			unlocked_at INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS self_unlocks_user_idx ON self_unlocks (user_name)`,
+	},
+}
+
+// create/migrate db schema up to the latest version
+func migrate(db *sql.DB, kind string) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER NOT NULL)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	var version int
+	err = db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return fmt.Errorf("failed to get schema version: %w", err)
+	}
+
+	steps := migrations[kind]
+	for i := version; i < len(steps); i++ {
+		if err := migrateStep(db, i+1, steps[i]); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+// Run migration and save its version in one transaction,
+// so failed migration is rolled back and retried on next start.
+// MySQL commits DDL implicitly, so MySQL migrations must be
+// single idempotent statements(CREATE TABLE IF NOT EXISTS, etc.).
+func migrateStep(db *sql.DB, version int, step string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// MySQL can't run several statements in one Exec by default,
+	// so MySQL migrations must be single statements
+	if _, err := tx.Exec(step); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES (` + strconv.Itoa(version) + `)`); err != nil {
+		return fmt.Errorf("failed to save version: %w", err)
+	}
+
+	return tx.Commit()
+}
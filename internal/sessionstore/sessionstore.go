@@ -0,0 +1,306 @@
+package sessionstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alexedwards/scs/mysqlstore"
+	"github.com/alexedwards/scs/postgresstore"
+	"github.com/alexedwards/scs/sqlite3store"
+	"github.com/alexedwards/scs/v2"
+	"github.com/alexedwards/scs/v2/memstore"
+	"github.com/go-sql-driver/mysql"
+
+	// database/sql drivers: "postgres", "sqlite"
+	_ "github.com/glebarez/go-sqlite"
+	_ "github.com/lib/pq"
+)
+
+// Supported session store kinds
+const (
+	Memory   = "memory"
+	SQLite   = "sqlite"
+	Postgres = "postgres"
+	MySQL    = "mysql"
+)
+
+// Kinds is list of supported session store kinds
+var Kinds = []string{Memory, SQLite, Postgres, MySQL}
+
+// Options of session store.
+// Host, Port, User, Pass, Name and TLS are used by Postgres & MySQL,
+// Path is used by SQLite, Memory needs nothing.
+type Options struct {
+	Kind string
+	Host string
+	Port int
+	User string
+	Pass string
+	Name string
+	// MySQL: "false", "true", "skip-verify", "preferred";
+	// Postgres: sslmode("disable", "require", "verify-ca", "verify-full")
+	TLS  string
+	Path string
+	// logs expired sessions cleanup errors, nil - discard
+	Logger *slog.Logger
+}
+
+// Store is scs.Store with its db pool(nil for Memory).
+// It tracks store health: result of the last store operation.
+type Store struct {
+	scs.Store
+	DB      *sql.DB
+	kind    string
+	stop    func()
+	logger  *slog.Logger
+	lastErr atomic.Pointer[error]
+
+	// Memory store has no way to count sessions, so they are tracked here;
+	// enrollment markers & self-unlocks of Memory store are kept here too
+	mu       sync.Mutex
+	expiry   map[string]time.Time
+	enrolled map[string]bool
+	unlocks  map[string][]time.Time
+}
+
+// Find is scs.Store.Find with health tracking
+func (s *Store) Find(token string) ([]byte, bool, error) {
+	b, found, err := s.Store.Find(token)
+	s.setHealth(err)
+	return b, found, err
+}
+
+// Commit is scs.Store.Commit with health tracking
+func (s *Store) Commit(token string, b []byte, expiry time.Time) error {
+	err := s.Store.Commit(token, b, expiry)
+	s.setHealth(err)
+	if err == nil && s.expiry != nil {
+		s.mu.Lock()
+		s.expiry[token] = expiry
+		// drop expired tokens from time to time, even if Count is never called
+		if len(s.expiry)%1024 == 0 {
+			s.pruneExpired()
+		}
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// Delete is scs.Store.Delete with health tracking
+func (s *Store) Delete(token string) error {
+	err := s.Store.Delete(token)
+	s.setHealth(err)
+	if err == nil && s.expiry != nil {
+		s.mu.Lock()
+		delete(s.expiry, token)
+		s.mu.Unlock()
+	}
+	return err
+}
+
+func (s *Store) setHealth(err error) {
+	if err == nil {
+		s.lastErr.Store(nil)
+		return
+	}
+	s.lastErr.Store(&err)
+}
+
+// Healthy returns nil if the last store operation succeeded, its error otherwise
+func (s *Store) Healthy() error {
+	if err := s.lastErr.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+// Check is store's readiness: result of the last store operation,
+// no db query is made while it succeeds. If it failed, store is checked
+// again with a query on sessions table(and health is updated), so the store
+// is back to ready without user requests(ex. readiness probe took it out of LB).
+func (s *Store) Check(ctx context.Context) error {
+	if s.Healthy() == nil {
+		return nil
+	}
+
+	_, err := s.Count(ctx)
+	return err
+}
+
+// queries to count not expired sessions
+var countQueries = map[string]string{
+	MySQL:    `SELECT COUNT(*) FROM sessions WHERE UTC_TIMESTAMP(6) < expiry`,
+	Postgres: `SELECT COUNT(*) FROM sessions WHERE current_timestamp < expiry`,
+	SQLite:   `SELECT COUNT(*) FROM sessions WHERE julianday('now') < expiry`,
+}
+
+// Count returns number of not expired sessions
+func (s *Store) Count(ctx context.Context) (int, error) {
+	if s.DB == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.pruneExpired()
+		return len(s.expiry), nil
+	}
+
+	var count int
+	err := s.DB.QueryRowContext(ctx, countQueries[s.kind]).Scan(&count)
+	s.setHealth(err)
+	return count, err
+}
+
+// remove expired tracked tokens, s.mu must be locked
+func (s *Store) pruneExpired() {
+	now := time.Now()
+	for token, expiry := range s.expiry {
+		if !now.Before(expiry) {
+			delete(s.expiry, token)
+		}
+	}
+}
+
+// Close stops expired sessions cleanup and closes db pool
+func (s *Store) Close() error {
+	s.stop()
+	if s.DB == nil {
+		return nil
+	}
+
+	return s.DB.Close()
+}
+
+// Open opens session store of given kind, creates/migrates db schema if needed
+func Open(opts Options) (*Store, error) {
+	if opts.Kind == Memory {
+		store := memstore.New()
+		return &Store{Store: store, kind: opts.Kind, stop: store.StopCleanup, expiry: make(map[string]time.Time), enrolled: make(map[string]bool), unlocks: make(map[string][]time.Time)}, nil
+	}
+
+	driver, dsn, err := DSN(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := openDB(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s session db: %w", opts.Kind, err)
+	}
+
+	if err := migrate(db, opts.Kind); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate %s session db: %w", opts.Kind, err)
+	}
+
+	// scs stores' cleanup is disabled(0 interval), see startCleanup
+	s := &Store{DB: db, kind: opts.Kind, logger: opts.Logger}
+	switch opts.Kind {
+	case MySQL:
+		s.Store = mysqlstore.NewWithCleanupInterval(db, 0)
+	case Postgres:
+		s.Store = postgresstore.NewWithCleanupInterval(db, 0)
+	case SQLite:
+		s.Store = sqlite3store.NewWithCleanupInterval(db, 0)
+	}
+	if s.logger == nil {
+		s.logger = slog.New(slog.DiscardHandler)
+	}
+	s.stop = s.startCleanup(cleanupInterval)
+
+	return s, nil
+}
+
+// OpenRetry calls Open until success or retries are over.
+// Wait between attempts starts with backoff and doubles every time(max is maxBackoff).
+// onRetry(may be nil) is called before every wait, ex. to log the error.
+func OpenRetry(opts Options, retries int, backoff, maxBackoff time.Duration, onRetry func(attempt int, wait time.Duration, err error)) (*Store, error) {
+	for attempt := 1; ; attempt++ {
+		store, err := Open(opts)
+		if err == nil || attempt > retries {
+			return store, err
+		}
+
+		if onRetry != nil {
+			onRetry(attempt, backoff, err)
+		}
+		time.Sleep(backoff)
+
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// DSN returns database/sql driver name and DSN for session store options
+func DSN(opts Options) (string, string, error) {
+	switch opts.Kind {
+	case SQLite:
+		// WAL & busy timeout: sessions are written on every request
+		query := url.Values{}
+		query.Add("_pragma", "busy_timeout(5000)")
+		query.Add("_pragma", "journal_mode(WAL)")
+		return "sqlite", "file:" + opts.Path + "?" + query.Encode(), nil
+
+	case Postgres:
+		query := url.Values{}
+		if len(opts.TLS) != 0 {
+			query.Set("sslmode", opts.TLS)
+		}
+		dsn := url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(opts.User, opts.Pass),
+			Host:     hostPort(opts.Host, opts.Port),
+			Path:     "/" + opts.Name,
+			RawQuery: query.Encode(),
+		}
+		return "postgres", dsn.String(), nil
+
+	case MySQL:
+		cfg := mysql.NewConfig()
+		cfg.User = opts.User
+		cfg.Passwd = opts.Pass
+		cfg.Net = "tcp"
+		cfg.Addr = hostPort(opts.Host, opts.Port)
+		cfg.DBName = opts.Name
+		cfg.ParseTime = true
+		if len(opts.TLS) != 0 {
+			cfg.TLSConfig = opts.TLS
+		}
+		return "mysql", cfg.FormatDSN(), nil
+
+	default:
+		return "", "", fmt.Errorf("unsupported session store %q", opts.Kind)
+	}
+}
+
+// host:port, port is omitted if 0(driver's default is used)
+func hostPort(host string, port int) string {
+	if port == 0 {
+		return host
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// The openDB() function wraps sql.Open() and returns a sql.DB connection pool
+// for a given driver & DSN.
+func openDB(driver, dsn string) (*sql.DB, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
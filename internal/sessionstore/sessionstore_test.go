@@ -0,0 +1,184 @@
+package sessionstore
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestSQLite(t *testing.T, path string) *Store {
+	t.Helper()
+
+	s, err := Open(Options{Kind: SQLite, Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	return s
+}
+
+func schemaVersion(t *testing.T, s *Store) int {
+	t.Helper()
+
+	var version int
+	if err := s.DB.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
+
+func TestSQLiteStore(t *testing.T) {
+	s := openTestSQLite(t, filepath.Join(t.TempDir(), "sessions.db"))
+	ctx := context.Background()
+
+	if err := s.Commit("token", []byte("data"), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Commit("expired", []byte("data"), time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	b, found, err := s.Find("token")
+	if err != nil || !found || string(b) != "data" {
+		t.Errorf("Find(token) = %q, %t, %v", b, found, err)
+	}
+	if _, found, _ := s.Find("expired"); found {
+		t.Error("expired session is found")
+	}
+	if count, err := s.Count(ctx); err != nil || count != 1 {
+		t.Errorf("Count() = %d, %v; want 1", count, err)
+	}
+
+	if err := s.Delete("token"); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := s.Find("token"); found {
+		t.Error("deleted session is found")
+	}
+
+	if _, err := s.DB.Exec(cleanupQueries[SQLite]); err != nil {
+		t.Errorf("cleanup: %v", err)
+	}
+
+	if err := s.SetEnrolled(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetEnrolled(ctx, "alice"); err != nil {
+		t.Errorf("existing marker: %v", err)
+	}
+	if enrolled, err := s.Enrolled(ctx, "alice"); err != nil || !enrolled {
+		t.Errorf("Enrolled(alice) = %t, %v", enrolled, err)
+	}
+	if err := s.ResetEnrolled(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if enrolled, _ := s.Enrolled(ctx, "alice"); enrolled {
+		t.Error("marker isn't reset")
+	}
+}
+
+// applied migrations aren't run again, failed migration isn't saved
+func TestMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.db")
+	s := openTestSQLite(t, path)
+	if version := schemaVersion(t, s); version != len(migrations[SQLite]) {
+		t.Fatalf("schema version %d, want %d", version, len(migrations[SQLite]))
+	}
+	if err := migrate(s.DB, SQLite); err != nil {
+		t.Errorf("second migrate: %v", err)
+	}
+
+	saved := migrations[SQLite]
+	t.Cleanup(func() { migrations[SQLite] = saved })
+	migrations[SQLite] = append(saved[:len(saved):len(saved)],
+		`CREATE TABLE broken (id INTEGER);
+		INSERT INTO missing_table VALUES (1)`)
+
+	if err := migrate(s.DB, SQLite); err == nil {
+		t.Fatal("broken migration must fail")
+	}
+	if version := schemaVersion(t, s); version != len(saved) {
+		t.Errorf("schema version %d after failed migration, want %d", version, len(saved))
+	}
+	var count int
+	if err := s.DB.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'broken'`).Scan(&count); err != nil || count != 0 {
+		t.Errorf("failed migration isn't rolled back: %d, %v", count, err)
+	}
+}
+
+// failed store operation makes store not ready until a query succeeds again
+func TestCheck(t *testing.T) {
+	s := openTestSQLite(t, filepath.Join(t.TempDir(), "sessions.db"))
+	ctx := context.Background()
+
+	if err := s.Check(ctx); err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+
+	if _, err := s.DB.Exec(`ALTER TABLE sessions RENAME TO sessions_moved`); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Find("token"); err == nil {
+		t.Fatal("Find without sessions table must fail")
+	}
+	if s.Healthy() == nil {
+		t.Error("failed Find isn't tracked")
+	}
+	if err := s.Check(ctx); err == nil {
+		t.Error("store without sessions table must not be ready")
+	}
+
+	if _, err := s.DB.Exec(`ALTER TABLE sessions_moved RENAME TO sessions`); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Check(ctx); err != nil {
+		t.Errorf("restored store: %v", err)
+	}
+	if err := s.Healthy(); err != nil {
+		t.Errorf("health isn't updated by check: %v", err)
+	}
+}
+
+// self-unlocks cap: per user, sliding window, kept in sqlite db across reopen
+func TestAllowUnlock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.db")
+	// memory store isn't closed: scs memstore's StopCleanup races with
+	// its cleanup goroutine start if called right after New
+	memory, err := Open(Options{Kind: Memory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	const window = 200 * time.Millisecond
+
+	for name, s := range map[string]*Store{"memory": memory, "sqlite": openTestSQLite(t, path)} {
+		for i := range 2 {
+			if ok, _, err := s.AllowUnlock(ctx, "alice", 2, window); err != nil || !ok {
+				t.Errorf("%s: unlock %d = %t, %v; want allowed", name, i+1, ok, err)
+			}
+		}
+		ok, wait, err := s.AllowUnlock(ctx, "alice", 2, window)
+		if err != nil || ok {
+			t.Errorf("%s: unlock over cap = %t, %v; want rejected", name, ok, err)
+		}
+		if wait <= 0 || wait > window {
+			t.Errorf("%s: wait %s, want (0, %s]", name, wait, window)
+		}
+		if ok, _, _ := s.AllowUnlock(ctx, "bob", 2, window); !ok {
+			t.Errorf("%s: other user's unlock must be allowed", name)
+		}
+
+		time.Sleep(window)
+		if ok, _, err := s.AllowUnlock(ctx, "alice", 2, window); err != nil || !ok {
+			t.Errorf("%s: unlock after window = %t, %v; want allowed", name, ok, err)
+		}
+	}
+
+	// the cap isn't reset by restart
+	s := openTestSQLite(t, path)
+	if ok, _, err := s.AllowUnlock(ctx, "alice", 1, time.Hour); err != nil || ok {
+		t.Errorf("unlock after reopen = %t, %v; want rejected", ok, err)
+	}
+}
@@ -0,0 +1,96 @@
+package sessionstore
+
+import (
+	"context"
+	"time"
+)
+
+/*
+Self-unlocks of user's token, capped in sliding window(ex. selfUnlockMaxPerDay in 24h).
+Unlocks are kept in 'self_unlocks' table of session db(unix ms), so the cap survives restarts
+and is shared by portal instances using the same db. Memory store keeps them in memory(lost on restart).
+*/
+
+type unlockQueries struct {
+	prune string
+	count string
+	add   string
+}
+
+var unlockQueriesByKind = map[string]unlockQueries{
+	MySQL: {
+		prune: `DELETE FROM self_unlocks WHERE user_name = ? AND unlocked_at <= ?`,
+		count: `SELECT COUNT(*), COALESCE(MIN(unlocked_at), 0) FROM self_unlocks WHERE user_name = ?`,
+		add:   `INSERT INTO self_unlocks (user_name, unlocked_at) VALUES (?, ?)`,
+	},
+	Postgres: {
+		prune: `DELETE FROM self_unlocks WHERE user_name = $1 AND unlocked_at <= $2`,
+		count: `SELECT COUNT(*), COALESCE(MIN(unlocked_at), 0) FROM self_unlocks WHERE user_name = $1`,
+		add:   `INSERT INTO self_unlocks (user_name, unlocked_at) VALUES ($1, $2)`,
+	},
+	SQLite: {
+		prune: `DELETE FROM self_unlocks WHERE user_name = $1 AND unlocked_at <= $2`,
+		count: `SELECT COUNT(*), COALESCE(MIN(unlocked_at), 0) FROM self_unlocks WHERE user_name = $1`,
+		add:   `INSERT INTO self_unlocks (user_name, unlocked_at) VALUES ($1, $2)`,
+	},
+}
+
+// AllowUnlock records self-unlock of user and returns true if it's allowed(less than max unlocks in window),
+// otherwise returns false and time to wait for the next allowed unlock(rejected unlocks aren't recorded)
+func (s *Store) AllowUnlock(ctx context.Context, user string, max int, window time.Duration) (bool, time.Duration, error) {
+	now := time.Now()
+
+	if s.DB == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		unlocks := s.unlocks[user]
+		i := 0
+		for i < len(unlocks) && now.Sub(unlocks[i]) >= window {
+			i++
+		}
+		unlocks = unlocks[i:]
+		if len(unlocks) >= max {
+			s.unlocks[user] = unlocks
+			return false, unlocks[0].Add(window).Sub(now), nil
+		}
+
+		s.unlocks[user] = append(unlocks, now)
+		return true, 0, nil
+	}
+
+	ok, wait, err := s.allowUnlockDB(ctx, user, max, window, now)
+	s.setHealth(err)
+	return ok, wait, err
+}
+
+// prune, count & record unlock in one transaction
+// (with default isolation of MySQL/Postgres two concurrent unlocks of one user may both pass, that's accepted)
+func (s *Store) allowUnlockDB(ctx context.Context, user string, max int, window time.Duration, now time.Time) (bool, time.Duration, error) {
+	q := unlockQueriesByKind[s.kind]
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, q.prune, user, now.Add(-window).UnixMilli()); err != nil {
+		return false, 0, err
+	}
+
+	var count int
+	var oldest int64
+	if err := tx.QueryRowContext(ctx, q.count, user).Scan(&count, &oldest); err != nil {
+		return false, 0, err
+	}
+	if count >= max {
+		return false, time.UnixMilli(oldest).Add(window).Sub(now), tx.Commit()
+	}
+
+	if _, err := tx.ExecContext(ctx, q.add, user, now.UnixMilli()); err != nil {
+		return false, 0, err
+	}
+
+	return true, 0, tx.Commit()
+}
@@ -67,15 +67,28 @@ func NotBlank(value string) bool {
 	return strings.TrimSpace(value) != ""
 }
 
-// Check OTP, 6 symbols, all digits
-func ValidOTP(value string) bool {
+// Check OTP, all digits, as many as user's token has(ex. 6 or 8)
+func ValidOTP(value string, digits int) bool {
 	for _, c := range value {
 		if !unicode.IsDigit(c) {
 			return false
 		}
 	}
 
-	return len(value) == 6
+	return len(value) == digits
+}
+
+// Distinct() returns true if all values are different
+func Distinct[T comparable](values ...T) bool {
+	seen := make(map[T]struct{}, len(values))
+	for _, value := range values {
+		if _, ok := seen[value]; ok {
+			return false
+		}
+		seen[value] = struct{}{}
+	}
+
+	return true
 }
 
 // MaxChars() returns true if a value contains no more than n characters.
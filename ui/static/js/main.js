@@ -8,7 +8,55 @@ for (var i = 0; i < navLinks.length; i++) {
 	}
 }
 
-// JavaScript to handle the click event
-document.getElementById('showQRReissueOverlay').addEventListener('click', function() {
-	document.getElementById('QRReissueOverlay').style.display = 'flex';
-});
+// Poll QR reissue job status, reload page when it's finished
+// (server shows the result and the new QR)
+var reissueStatus = document.getElementById('reissue-status');
+
+if (reissueStatus) {
+	var jobID = reissueStatus.getAttribute('data-job-id');
+	var stateText = reissueStatus.querySelector('.reissue-state');
+
+	var pollReissue = function() {
+		fetch('/qr/reissue/' + encodeURIComponent(jobID), {credentials: 'same-origin'})
+			.then(function(resp) {
+				if (!resp.ok) {
+					throw new Error(resp.status);
+				}
+				return resp.json();
+			})
+			.then(function(job) {
+				if (job.state == 'done' || job.state == 'failed') {
+					window.location.reload();
+					return;
+				}
+				var text = reissueStatus.getAttribute('data-text-' + job.state);
+				if (text) {
+					stateText.textContent = text;
+				}
+				setTimeout(pollReissue, 2000);
+			})
+			.catch(function() {
+				// job is lost(ex. portal restart) - reload to show the result
+				window.location.reload();
+			});
+	};
+
+	setTimeout(pollReissue, 2000);
+}
+
+// Remove revealed QR from the page after its time(server doesn't serve QR png after it either),
+// "Show QR" button is shown again
+var qrRevealed = document.getElementById('qr-revealed');
+
+if (qrRevealed) {
+	var revealSeconds = parseInt(qrRevealed.getAttribute('data-expires'), 10);
+
+	setTimeout(function() {
+		qrRevealed.remove();
+		var revealedLinks = document.getElementById('qr-revealed-links');
+		if (revealedLinks) {
+			revealedLinks.remove();
+		}
+		document.getElementById('qr-hidden').hidden = false;
+	}, revealSeconds * 1000);
+}